@@ -0,0 +1,104 @@
+// catalog_zones.go
+
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// defaultViewName is the name of the NetBox DNS default view.
+const defaultViewName = "_default_"
+
+// catalogZoneFor returns the catalog zone configured for a NetBox DNS view.
+// Zones without a view belong to the default view.
+func catalogZoneFor(viewName string, config *Config) (CatalogZone, bool) {
+	if viewName == "" {
+		viewName = defaultViewName
+	}
+	catalog, exists := config.CatalogZones[viewName]
+	return catalog, exists
+}
+
+// catalogMemberName returns the owner name of a member zone in a catalog zone
+// (RFC 9432 section 4.2). The unique label is the SHA-1 hash of the member
+// zone name in lower-case wire format, as generated by BIND.
+func catalogMemberName(zoneName, catalogZone string) string {
+	var wire []byte
+	for _, label := range strings.Split(strings.TrimSuffix(canonicalName(zoneName), "."), ".") {
+		wire = append(wire, byte(len(label)))
+		wire = append(wire, label...)
+	}
+	wire = append(wire, 0)
+
+	sum := sha1.Sum(wire)
+	return hex.EncodeToString(sum[:]) + ".zones." + canonicalName(catalogZone)
+}
+
+// updateCatalogMembership adds zoneName to or removes it from the catalog zone of its view.
+// The event is "created" or "deleted". Views without a catalog zone are ignored.
+// The zone name must be in its ASCII form, as the member label is hashed from it.
+// It returns the catalog zone that was changed, if any.
+func updateCatalogMembership(event, zoneName, viewName string, config *Config) (string, error) {
+	catalog, exists := catalogZoneFor(viewName, config)
+	if !exists {
+		return "", nil
+	}
+	if !isASCII(zoneName) {
+		return catalog.Zone, fmt.Errorf("zone name %q is not in its ASCII form", zoneName)
+	}
+
+	primary := catalog.Primary
+	if primary == "" {
+		primary = config.BindServerAddress
+	}
+
+	script := ConstructCatalogMemberScript(
+		extractHost(primary),
+		extractPort(primary),
+		catalogMemberName(zoneName, catalog.Zone),
+		canonicalName(zoneName),
+		event,
+	)
+
+	logDebug("Outgoing nsupdate script for catalog zone", "catalog", catalog.Zone, "script", script)
+
+	if err := ExecuteNSUpdate(script, config); err != nil {
+		return catalog.Zone, err
+	}
+	return catalog.Zone, nil
+}
+
+// removeCatalogMembership removes zoneName from the catalog zone of view. If
+// only the ID of the view is known, e.g. from a snapshot after a restart, the
+// zone is removed from every catalog zone except the one of keepView, as
+// removing a member that does not exist is harmless. It returns the catalog
+// zones that were changed.
+func removeCatalogMembership(zoneName string, view NamedObject, keepView string, config *Config) (string, error) {
+	if view.Name != "" || view.ID == 0 {
+		return updateCatalogMembership("deleted", zoneName, view.Name, config)
+	}
+
+	keep, _ := catalogZoneFor(keepView, config)
+	var viewNames []string
+	for viewName := range config.CatalogZones {
+		viewNames = append(viewNames, viewName)
+	}
+	sort.Strings(viewNames)
+
+	var changed []string
+	for _, viewName := range viewNames {
+		catalog := config.CatalogZones[viewName]
+		if keepView != "" && sameName(catalog.Zone, keep.Zone) {
+			continue
+		}
+		if _, err := updateCatalogMembership("deleted", zoneName, viewName, config); err != nil {
+			return strings.Join(changed, ","), err
+		}
+		changed = append(changed, catalog.Zone)
+	}
+	return strings.Join(changed, ","), nil
+}
@@ -0,0 +1,142 @@
+// cname_conflict.go
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// cnameFallbackTypes are queried individually when the server does not answer ANY queries (RFC 8482).
+var cnameFallbackTypes = []string{
+	"A", "AAAA", "CAA", "DNAME", "HINFO", "MX", "NAPTR", "NS", "PTR", "SRV", "SSHFP", "TLSA", "TXT",
+}
+
+// cnameCompatibleTypes may coexist with a CNAME record (RFC 1034 section 3.6.2, RFC 4035).
+var cnameCompatibleTypes = map[string]bool{
+	"CNAME": true,
+	"RRSIG": true,
+	"NSEC":  true,
+	"KEY":   true,
+}
+
+// CNAMEConflictError reports RRsets that conflict with a CNAME record at the same owner name.
+type CNAMEConflictError struct {
+	FQDN       string
+	RecordType string
+	Conflicts  []string
+}
+
+// Error implements the error interface.
+func (e *CNAMEConflictError) Error() string {
+	if e.RecordType == "CNAME" {
+		return fmt.Sprintf("CNAME conflict at %s: CNAME cannot coexist with existing %s RRsets", e.FQDN, strings.Join(e.Conflicts, ", "))
+	}
+	return fmt.Sprintf("CNAME conflict at %s: %s cannot coexist with existing %s RRset", e.FQDN, e.RecordType, strings.Join(e.Conflicts, ", "))
+}
+
+// updateFailureMessage returns the log message for a record update that failed with err.
+func updateFailureMessage(err error) string {
+	var conflict *CNAMEConflictError
+	if errors.As(err, &conflict) {
+		return "Rejected conflicting DNS record"
+	}
+	return "Failed to execute nsupdate"
+}
+
+// checkCNAMEConflict queries the server for RRsets at fqdn that would violate the
+// CNAME-and-other-data rule if a record of recordType was added. The changes
+// scheduled by earlier jobs of the same batch are applied to the answer, and the
+// replaced record, if any, is removed by the same update and is ignored. It must
+// be called with the lock on fqdn held to be conclusive. Query failures are
+// logged; with CNAMEConflictFailOpen only the scheduled changes are checked,
+// otherwise the failure is returned.
+func checkCNAMEConflict(fqdn, recordType string, replaced *ResourceRecord, scheduled *scheduledChanges, config *Config) error {
+	if !config.CNAMEConflictCheck {
+		return nil
+	}
+
+	var existing []ResourceRecord
+	var err error
+	if recordType == "CNAME" {
+		existing, err = queryAllRRsets(fqdn, config)
+	} else {
+		existing, err = ExecuteDig(fqdn, "CNAME", config)
+	}
+	if err != nil {
+		logError("CNAME conflict check failed",
+			"fqdn", fqdn,
+			"record_type", recordType,
+			"fail_open", config.CNAMEConflictFailOpen,
+			"err", err,
+		)
+		if !config.CNAMEConflictFailOpen {
+			return fmt.Errorf("CNAME conflict check at %s failed: %v", fqdn, err)
+		}
+		existing = nil
+	}
+	if scheduled != nil {
+		existing = scheduled.apply(fqdn, existing)
+	}
+
+	conflicts := make(map[string]bool)
+	for _, rr := range existing {
+		if !sameName(rr.Name, fqdn) || isReplacedRecord(rr, replaced) {
+			continue
+		}
+		if recordType == "CNAME" && !cnameCompatibleTypes[rr.Type] {
+			conflicts[rr.Type] = true
+		} else if recordType != "CNAME" && rr.Type == "CNAME" {
+			conflicts[rr.Type] = true
+		}
+	}
+	if len(conflicts) == 0 {
+		return nil
+	}
+
+	conflictError := &CNAMEConflictError{FQDN: fqdn, RecordType: recordType}
+	for rrType := range conflicts {
+		conflictError.Conflicts = append(conflictError.Conflicts, rrType)
+	}
+	sort.Strings(conflictError.Conflicts)
+	return conflictError
+}
+
+// queryAllRRsets returns all RRsets at fqdn, falling back to per-type queries
+// when the server answers ANY queries with a synthesized HINFO record (RFC 8482).
+func queryAllRRsets(fqdn string, config *Config) ([]ResourceRecord, error) {
+	records, err := ExecuteDig(fqdn, "ANY", config)
+	if err != nil {
+		return nil, err
+	}
+	if !(len(records) == 1 && records[0].Type == "HINFO" && strings.Contains(records[0].Value, "RFC8482")) {
+		return records, nil
+	}
+
+	records = nil
+	for _, rrType := range cnameFallbackTypes {
+		answer, err := ExecuteDig(fqdn, rrType, config)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, answer...)
+	}
+	return records, nil
+}
+
+// isReplacedRecord reports whether rr is the record removed by the same update.
+func isReplacedRecord(rr ResourceRecord, replaced *ResourceRecord) bool {
+	if replaced == nil {
+		return false
+	}
+	return rr.Type == replaced.Type &&
+		sameName(rr.Name, replaced.Name) &&
+		sameRecordValue(rr.Value, replaced.Value)
+}
+
+// sameRecordValue reports whether two record values are equal, ignoring case and a trailing dot.
+func sameRecordValue(a, b string) bool {
+	return strings.EqualFold(strings.TrimSuffix(strings.TrimSpace(a), "."), strings.TrimSuffix(strings.TrimSpace(b), "."))
+}
@@ -4,7 +4,12 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
+	"net/netip"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Config represents the application configuration.
@@ -14,8 +19,171 @@ type Config struct {
 	TSIGKeyFile       string `json:"tsig_key_file"`
 	LogLevel          string `json:"log_level"`
 	LogFormat         string `json:"log_format"`
+
+	// ReadHeaderTimeout, ReadTimeout, WriteTimeout and IdleTimeout are the HTTP server timeouts in seconds.
+	ReadHeaderTimeout int `json:"read_header_timeout"`
+	ReadTimeout       int `json:"read_timeout"`
+	WriteTimeout      int `json:"write_timeout"`
+	IdleTimeout       int `json:"idle_timeout"`
+	// MaxHeaderBytes caps the size of request headers.
+	MaxHeaderBytes int `json:"max_header_bytes"`
+	// MaxBodyBytes caps the size of webhook payloads.
+	MaxBodyBytes int64 `json:"max_body_bytes"`
+
+	// TLSCertFile and TLSKeyFile enable the HTTPS listener; they are reloaded when they change on disk.
+	TLSCertFile string `json:"tls_cert_file"`
+	TLSKeyFile  string `json:"tls_key_file"`
+	// TLSClientCAFile requires clients to present a certificate issued by one of these CAs.
+	TLSClientCAFile string `json:"tls_client_ca_file"`
+	// TLSAllowedClientNames restricts client certificates to these common or DNS names.
+	TLSAllowedClientNames []string `json:"tls_allowed_client_names"`
+
+	// WebhookSecret verifies the X-Hook-Signature header of webhooks; empty disables verification.
+	WebhookSecret string `json:"webhook_secret"`
+	// WebhookSecretPrevious is also accepted during a secret rotation window.
+	WebhookSecretPrevious string `json:"webhook_secret_previous"`
+	// WebhookSecretPreviousExpires ends the rotation window (RFC 3339); empty accepts the previous secret indefinitely.
+	WebhookSecretPreviousExpires string `json:"webhook_secret_previous_expires"`
+
+	webhookSecretPreviousExpires time.Time
+
+	// AllowedSources maps endpoint paths to the CIDRs allowed to reach them; endpoints without an entry are open.
+	AllowedSources map[string][]string `json:"allowed_sources"`
+	// TrustedProxies lists the CIDRs of load balancers whose X-Forwarded-For header is honored.
+	TrustedProxies []string `json:"trusted_proxies"`
+
+	allowedSources map[string][]netip.Prefix
+	trustedProxies []netip.Prefix
+
+	// CNAMEConflictCheck enables the pre-flight CNAME-and-other-data conflict check.
+	CNAMEConflictCheck bool `json:"cname_conflict_check"`
+	// CNAMEConflictFailOpen applies changes whose CNAME conflict query failed; otherwise they are rejected.
+	CNAMEConflictFailOpen bool `json:"cname_conflict_fail_open"`
+
+	// PTRSource selects the single source of truth for PTR records (PTRSourceService or PTRSourceNetBox).
+	PTRSource string `json:"ptr_source"`
+	// ExplicitPTRFile is the file persisting the PTR records managed explicitly in NetBox reverse zones.
+	ExplicitPTRFile string `json:"explicit_ptr_file"`
+
+	// ClasslessReverseZones lists RFC 2317 classless reverse delegations.
+	ClasslessReverseZones []ClasslessReverseZone `json:"classless_reverse_zones"`
+
+	// ReverseTTL controls the TTL of auto-generated PTR records.
+	ReverseTTL ReverseTTLPolicy `json:"reverse_ttl"`
+
+	// ReverseZones lists the reverse zones the DNS server is authoritative for.
+	// PTR updates for addresses outside these zones are skipped.
+	ReverseZones []ReverseZone `json:"reverse_zones"`
+	// ReverseZoneDiscovery discovers reverse zones via SOA lookups when ReverseZones is empty.
+	ReverseZoneDiscovery bool `json:"reverse_zone_discovery"`
+	// ReverseZoneCacheTTL is the number of seconds discovered reverse zones are cached.
+	ReverseZoneCacheTTL int `json:"reverse_zone_cache_ttl"`
+	// IPv6ReverseZoneLengths are the prefix lengths of the ip6.arpa zone cuts probed during discovery.
+	IPv6ReverseZoneLengths []int `json:"ipv6_reverse_zone_lengths"`
+
+	// IPv4MappedPTR selects how IPv4-mapped IPv6 addresses get PTR records
+	// (IPv4MappedSkip, IPv4MappedIPv4 or IPv4MappedIPv6).
+	IPv4MappedPTR string `json:"ipv4_mapped_ptr"`
+	// SkipULAPTR skips PTR records for IPv6 unique local addresses (fc00::/7).
+	SkipULAPTR bool `json:"skip_ula_ptr"`
+
+	// PTRIndexFile is the file persisting which forward records claim each PTR owner name.
+	PTRIndexFile string `json:"ptr_index_file"`
+	// PTRPrimaryPolicy selects the PTR targets of shared addresses (PTRPrimaryOldest, PTRPrimaryExplicit or PTRPrimaryAll).
+	PTRPrimaryPolicy string `json:"ptr_primary_policy"`
+	// PTRPrimaryField is the NetBox custom field flagging a record as the primary PTR target.
+	PTRPrimaryField string `json:"ptr_primary_field"`
+
+	// RecordIndexFile is the file persisting the records the service manages per zone.
+	RecordIndexFile string `json:"record_index_file"`
+	// ZoneDeletePurge selects what happens to managed records of deleted zones
+	// (ZonePurgeOff, ZonePurgeDryRun or ZonePurgeOn).
+	ZoneDeletePurge string `json:"zone_delete_purge"`
+
+	// CatalogZones maps NetBox DNS view names to the RFC 9432 catalog zone listing their zones.
+	CatalogZones map[string]CatalogZone `json:"catalog_zones"`
+
+	// ZoneApexSync pushes SOA and apex NS changes of updated zones to the DNS server.
+	ZoneApexSync bool `json:"zone_apex_sync"`
+
+	// PayloadProfiles map the JSON of custom NetBox webhook body templates, each served on its own path.
+	PayloadProfiles []PayloadProfile `json:"payload_profiles"`
+
+	// EventSources feed change sources besides NetBox DNS webhooks into the update pipeline.
+	EventSources []EventSource `json:"event_sources"`
+
+	// ReplayWindow is the number of seconds a webhook timestamp may differ from the current time; 0 disables the check.
+	ReplayWindow int `json:"replay_window"`
+	// ReplayAction selects what happens to webhooks outside the replay window (ReplayReject or ReplayQuarantine).
+	ReplayAction string `json:"replay_action"`
+	// ReplayQuarantineDir is the directory quarantined webhooks are stored in.
+	ReplayQuarantineDir string `json:"replay_quarantine_dir"`
+	// ReplayQuarantineMaxFiles is the maximum number of webhooks kept in the quarantine directory; 0 is unlimited.
+	ReplayQuarantineMaxFiles int `json:"replay_quarantine_max_files"`
+	// ReplayStateFile is the file persisting the timestamp of the newest change applied per object.
+	ReplayStateFile string `json:"replay_state_file"`
+	// ReplayStateRetention is the number of seconds the timestamps are kept if the replay window is disabled.
+	ReplayStateRetention int `json:"replay_state_retention"`
+
+	// DedupeRetention is the number of seconds accepted webhook deliveries are remembered
+	// to acknowledge duplicates without applying them again; 0 disables deduplication.
+	DedupeRetention int `json:"dedupe_retention"`
+
+	// BatchWindowMS is the number of milliseconds the webhooks following the first one of a
+	// request ID are collected into one batched update after the last one arrived; 0 disables batching.
+	BatchWindowMS int `json:"batch_window_ms"`
+	// BatchMaxRecords runs a batch right away once it holds this many records; 0 disables the limit.
+	BatchMaxRecords int `json:"batch_max_records"`
 }
 
+// CatalogZone configures the catalog zone of a NetBox DNS view.
+type CatalogZone struct {
+	Zone    string `json:"zone"`    // e.g. "catalog.example."
+	Primary string `json:"primary"` // Primary server of the catalog zone, defaults to bind_server_address
+}
+
+// ReverseZone maps a prefix to the reverse zone holding its PTR records.
+// Either field may be omitted: a prefix on an octet (IPv4) or nibble (IPv6)
+// boundary derives its zone, and a zone without a prefix matches by name.
+type ReverseZone struct {
+	Prefix string `json:"prefix"` // e.g. "10.0.0.0/8"
+	Zone   string `json:"zone"`   // e.g. "10.in-addr.arpa."
+
+	prefix netip.Prefix
+}
+
+// ReverseTTLPolicy controls the TTL of auto-generated PTR records.
+// By default PTR records inherit the TTL of their forward record.
+type ReverseTTLPolicy struct {
+	MinTTL int            `json:"min_ttl"` // 0 disables the lower bound
+	MaxTTL int            `json:"max_ttl"` // 0 disables the upper bound
+	Zones  map[string]int `json:"zones"`   // Fixed TTL per reverse zone, e.g. {"2.0.192.in-addr.arpa.": 3600}
+}
+
+// ClasslessReverseZone maps an IPv4 prefix longer than /24 to its RFC 2317 delegated zone.
+type ClasslessReverseZone struct {
+	Prefix string `json:"prefix"` // e.g. "192.0.2.64/26"
+	Zone   string `json:"zone"`   // e.g. "64/26.2.0.192.in-addr.arpa.", derived from the prefix if empty
+
+	prefix netip.Prefix
+}
+
+const (
+	// IPv4MappedSkip creates no PTR record for IPv4-mapped IPv6 addresses.
+	IPv4MappedSkip = "skip"
+	// IPv4MappedIPv4 creates the PTR record in in-addr.arpa for the embedded IPv4 address.
+	IPv4MappedIPv4 = "ipv4"
+	// IPv4MappedIPv6 creates the PTR record in ip6.arpa for the full IPv6 address.
+	IPv4MappedIPv6 = "ipv6"
+)
+
+const (
+	// PTRSourceService synthesizes PTR records from A/AAAA records and ignores PTR records managed by NetBox DNS.
+	PTRSourceService = "service"
+	// PTRSourceNetBox applies PTR records managed by NetBox DNS and does not synthesize PTR records.
+	PTRSourceNetBox = "netbox"
+)
+
 // LoadConfig loads the configuration from environment variables, a file, or defaults.
 func LoadConfig() (*Config, error) {
 	// Set default values
@@ -25,6 +193,42 @@ func LoadConfig() (*Config, error) {
 		TSIGKeyFile:       "/etc/nsupdate.key",
 		LogLevel:          "info",
 		LogFormat:         "logfmt",
+
+		ReadHeaderTimeout: 5,
+		ReadTimeout:       15,
+		WriteTimeout:      30,
+		IdleTimeout:       60,
+		MaxHeaderBytes:    16 << 10,
+		MaxBodyBytes:      4 << 20,
+
+		CNAMEConflictCheck:    true,
+		CNAMEConflictFailOpen: true,
+		PTRSource:             PTRSourceService,
+		ExplicitPTRFile:       "explicit_ptrs.json",
+
+		ReverseZoneCacheTTL:    3600,
+		IPv6ReverseZoneLengths: []int{32, 48, 56},
+
+		IPv4MappedPTR: IPv4MappedSkip,
+
+		PTRIndexFile:     "ptr_index.json",
+		PTRPrimaryPolicy: PTRPrimaryOldest,
+		PTRPrimaryField:  "ptr_primary",
+
+		RecordIndexFile: "record_index.json",
+		ZoneDeletePurge: ZonePurgeOff,
+		ZoneApexSync:    true,
+
+		DedupeRetention: 600,
+
+		ReplayAction:             ReplayReject,
+		ReplayQuarantineDir:      "quarantine",
+		ReplayQuarantineMaxFiles: 1000,
+		ReplayStateFile:          "replay_state.json",
+		ReplayStateRetention:     86400,
+
+		BatchWindowMS:   200,
+		BatchMaxRecords: 500,
 	}
 
 	// Override defaults with environment variables if set
@@ -43,6 +247,164 @@ func LoadConfig() (*Config, error) {
 	if val := os.Getenv("LOG_FORMAT"); val != "" {
 		config.LogFormat = val
 	}
+	if val := os.Getenv("HTTP_READ_HEADER_TIMEOUT"); val != "" {
+		if seconds, err := strconv.Atoi(val); err == nil {
+			config.ReadHeaderTimeout = seconds
+		}
+	}
+	if val := os.Getenv("HTTP_READ_TIMEOUT"); val != "" {
+		if seconds, err := strconv.Atoi(val); err == nil {
+			config.ReadTimeout = seconds
+		}
+	}
+	if val := os.Getenv("HTTP_WRITE_TIMEOUT"); val != "" {
+		if seconds, err := strconv.Atoi(val); err == nil {
+			config.WriteTimeout = seconds
+		}
+	}
+	if val := os.Getenv("HTTP_IDLE_TIMEOUT"); val != "" {
+		if seconds, err := strconv.Atoi(val); err == nil {
+			config.IdleTimeout = seconds
+		}
+	}
+	if val := os.Getenv("HTTP_MAX_HEADER_BYTES"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil {
+			config.MaxHeaderBytes = n
+		}
+	}
+	if val := os.Getenv("HTTP_MAX_BODY_BYTES"); val != "" {
+		if n, err := strconv.ParseInt(val, 10, 64); err == nil {
+			config.MaxBodyBytes = n
+		}
+	}
+	if val := os.Getenv("TLS_CERT_FILE"); val != "" {
+		config.TLSCertFile = val
+	}
+	if val := os.Getenv("TLS_KEY_FILE"); val != "" {
+		config.TLSKeyFile = val
+	}
+	if val := os.Getenv("TLS_CLIENT_CA_FILE"); val != "" {
+		config.TLSClientCAFile = val
+	}
+	if val := os.Getenv("TLS_ALLOWED_CLIENT_NAMES"); val != "" {
+		config.TLSAllowedClientNames = strings.Split(val, ",")
+	}
+	if val := os.Getenv("WEBHOOK_SECRET"); val != "" {
+		config.WebhookSecret = val
+	}
+	if val := os.Getenv("WEBHOOK_SECRET_PREVIOUS"); val != "" {
+		config.WebhookSecretPrevious = val
+	}
+	if val := os.Getenv("WEBHOOK_SECRET_PREVIOUS_EXPIRES"); val != "" {
+		config.WebhookSecretPreviousExpires = val
+	}
+	if val := os.Getenv("WEBHOOK_ALLOWED_SOURCES"); val != "" {
+		config.AllowedSources = map[string][]string{"/webhook": strings.Split(val, ",")}
+	}
+	if val := os.Getenv("TRUSTED_PROXIES"); val != "" {
+		config.TrustedProxies = strings.Split(val, ",")
+	}
+	if val := os.Getenv("CNAME_CONFLICT_CHECK"); val != "" {
+		if b, err := strconv.ParseBool(val); err == nil {
+			config.CNAMEConflictCheck = b
+		}
+	}
+	if val := os.Getenv("CNAME_CONFLICT_FAIL_OPEN"); val != "" {
+		if b, err := strconv.ParseBool(val); err == nil {
+			config.CNAMEConflictFailOpen = b
+		}
+	}
+
+	if val := os.Getenv("PTR_SOURCE"); val != "" {
+		config.PTRSource = val
+	}
+	if val := os.Getenv("EXPLICIT_PTR_FILE"); val != "" {
+		config.ExplicitPTRFile = val
+	}
+
+	if val := os.Getenv("PTR_MIN_TTL"); val != "" {
+		if ttl, err := strconv.Atoi(val); err == nil {
+			config.ReverseTTL.MinTTL = ttl
+		}
+	}
+	if val := os.Getenv("PTR_MAX_TTL"); val != "" {
+		if ttl, err := strconv.Atoi(val); err == nil {
+			config.ReverseTTL.MaxTTL = ttl
+		}
+	}
+
+	if val := os.Getenv("REVERSE_ZONE_DISCOVERY"); val != "" {
+		if b, err := strconv.ParseBool(val); err == nil {
+			config.ReverseZoneDiscovery = b
+		}
+	}
+
+	if val := os.Getenv("PTR_INDEX_FILE"); val != "" {
+		config.PTRIndexFile = val
+	}
+	if val := os.Getenv("PTR_PRIMARY_POLICY"); val != "" {
+		config.PTRPrimaryPolicy = val
+	}
+
+	if val := os.Getenv("IPV4_MAPPED_PTR"); val != "" {
+		config.IPv4MappedPTR = val
+	}
+	if val := os.Getenv("SKIP_ULA_PTR"); val != "" {
+		if b, err := strconv.ParseBool(val); err == nil {
+			config.SkipULAPTR = b
+		}
+	}
+
+	if val := os.Getenv("RECORD_INDEX_FILE"); val != "" {
+		config.RecordIndexFile = val
+	}
+	if val := os.Getenv("ZONE_DELETE_PURGE"); val != "" {
+		config.ZoneDeletePurge = val
+	}
+	if val := os.Getenv("REPLAY_WINDOW"); val != "" {
+		if seconds, err := strconv.Atoi(val); err == nil {
+			config.ReplayWindow = seconds
+		}
+	}
+	if val := os.Getenv("REPLAY_ACTION"); val != "" {
+		config.ReplayAction = val
+	}
+	if val := os.Getenv("REPLAY_QUARANTINE_DIR"); val != "" {
+		config.ReplayQuarantineDir = val
+	}
+	if val := os.Getenv("REPLAY_QUARANTINE_MAX_FILES"); val != "" {
+		if count, err := strconv.Atoi(val); err == nil {
+			config.ReplayQuarantineMaxFiles = count
+		}
+	}
+	if val := os.Getenv("REPLAY_STATE_FILE"); val != "" {
+		config.ReplayStateFile = val
+	}
+	if val := os.Getenv("REPLAY_STATE_RETENTION"); val != "" {
+		if seconds, err := strconv.Atoi(val); err == nil {
+			config.ReplayStateRetention = seconds
+		}
+	}
+	if val := os.Getenv("DEDUPE_RETENTION"); val != "" {
+		if seconds, err := strconv.Atoi(val); err == nil {
+			config.DedupeRetention = seconds
+		}
+	}
+	if val := os.Getenv("BATCH_WINDOW_MS"); val != "" {
+		if ms, err := strconv.Atoi(val); err == nil {
+			config.BatchWindowMS = ms
+		}
+	}
+	if val := os.Getenv("BATCH_MAX_RECORDS"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil {
+			config.BatchMaxRecords = n
+		}
+	}
+	if val := os.Getenv("ZONE_APEX_SYNC"); val != "" {
+		if b, err := strconv.ParseBool(val); err == nil {
+			config.ZoneApexSync = b
+		}
+	}
 
 	// Attempt to load configuration from file if it exists
 	configFile := "config.json"
@@ -59,5 +421,161 @@ func LoadConfig() (*Config, error) {
 		}
 	}
 
+	if (config.TLSCertFile == "") != (config.TLSKeyFile == "") {
+		return nil, fmt.Errorf("tls_cert_file and tls_key_file must be set together")
+	}
+	if config.TLSCertFile == "" && (config.TLSClientCAFile != "" || len(config.TLSAllowedClientNames) > 0) {
+		return nil, fmt.Errorf("client certificate verification requires tls_cert_file and tls_key_file")
+	}
+	if config.TLSClientCAFile == "" && len(config.TLSAllowedClientNames) > 0 {
+		return nil, fmt.Errorf("tls_allowed_client_names requires tls_client_ca_file")
+	}
+
+	// Parse the end of the secret rotation window
+	if config.WebhookSecretPreviousExpires != "" {
+		expires, err := time.Parse(time.RFC3339, config.WebhookSecretPreviousExpires)
+		if err != nil {
+			return nil, fmt.Errorf("webhook_secret_previous_expires: %v", err)
+		}
+		config.webhookSecretPreviousExpires = expires
+	}
+
+	// Parse the source allowlists and trusted proxies
+	config.allowedSources = make(map[string][]netip.Prefix)
+	for path, sources := range config.AllowedSources {
+		prefixes, err := parsePrefixes(sources)
+		if err != nil {
+			return nil, fmt.Errorf("allowed_sources %s: %v", path, err)
+		}
+		config.allowedSources[path] = prefixes
+	}
+	trustedProxies, err := parsePrefixes(config.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("trusted_proxies: %v", err)
+	}
+	config.trustedProxies = trustedProxies
+
+	config.PTRSource = strings.ToLower(config.PTRSource)
+	config.PTRPrimaryPolicy = strings.ToLower(config.PTRPrimaryPolicy)
+	config.IPv4MappedPTR = strings.ToLower(config.IPv4MappedPTR)
+	config.ZoneDeletePurge = strings.ToLower(config.ZoneDeletePurge)
+	config.ReplayAction = strings.ToLower(config.ReplayAction)
+
+	// Reject unknown modes instead of silently falling back to a default behavior
+	for _, setting := range []struct {
+		name    string
+		value   string
+		allowed []string
+	}{
+		{"ptr_source", config.PTRSource, []string{PTRSourceService, PTRSourceNetBox}},
+		{"ptr_primary_policy", config.PTRPrimaryPolicy, []string{PTRPrimaryOldest, PTRPrimaryExplicit, PTRPrimaryAll}},
+		{"ipv4_mapped_ptr", config.IPv4MappedPTR, []string{IPv4MappedSkip, IPv4MappedIPv4, IPv4MappedIPv6}},
+		{"zone_delete_purge", config.ZoneDeletePurge, []string{ZonePurgeOff, ZonePurgeDryRun, ZonePurgeOn}},
+		{"replay_action", config.ReplayAction, []string{ReplayReject, ReplayQuarantine}},
+	} {
+		if !containsString(setting.allowed, setting.value) {
+			return nil, fmt.Errorf("%s: unknown value %q, must be one of %s", setting.name, setting.value, strings.Join(setting.allowed, ", "))
+		}
+	}
+
+	// Validate the ip6.arpa zone cuts
+	for _, length := range config.IPv6ReverseZoneLengths {
+		if length <= 0 || length >= 128 || length%4 != 0 {
+			return nil, fmt.Errorf("ipv6 reverse zone length %d is not on a nibble boundary", length)
+		}
+	}
+
+	// Parse the classless reverse delegations
+	for i := range config.ClasslessReverseZones {
+		if err := config.ClasslessReverseZones[i].parse(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Parse the reverse zone mapping
+	for i := range config.ReverseZones {
+		if err := config.ReverseZones[i].parse(); err != nil {
+			return nil, err
+		}
+	}
+
+	paths := make(map[string]string)
+	for i := range config.PayloadProfiles {
+		profile := &config.PayloadProfiles[i]
+		if err := profile.parse(); err != nil {
+			return nil, err
+		}
+		if other, exists := paths[profile.Path]; exists {
+			return nil, fmt.Errorf("%s and payload profile %s share the path %s", other, profile.Name, profile.Path)
+		}
+		paths[profile.Path] = "payload profile " + profile.Name
+	}
+	for i := range config.EventSources {
+		source := &config.EventSources[i]
+		if err := source.parse(); err != nil {
+			return nil, err
+		}
+		if other, exists := paths[source.Path]; exists {
+			return nil, fmt.Errorf("%s and event source %s share the path %s", other, source.Name, source.Path)
+		}
+		paths[source.Path] = "event source " + source.Name
+	}
+
 	return config, nil
 }
+
+// parse validates the prefix and derives the zone name if it is not configured.
+func (c *ClasslessReverseZone) parse() error {
+	prefix, err := netip.ParsePrefix(c.Prefix)
+	if err != nil {
+		return fmt.Errorf("classless reverse zone: %v", err)
+	}
+	if !prefix.Addr().Is4() || prefix.Bits() <= 24 {
+		return fmt.Errorf("classless reverse zone: %s is not an IPv4 prefix longer than /24", c.Prefix)
+	}
+	c.prefix = prefix.Masked()
+
+	if c.Zone == "" {
+		// RFC 2317 section 4 naming: <first address>/<prefix length>.<c>.<b>.<a>.in-addr.arpa.
+		octets := c.prefix.Addr().As4()
+		c.Zone = fmt.Sprintf("%d/%d.%d.%d.%d.in-addr.arpa.", octets[3], c.prefix.Bits(), octets[2], octets[1], octets[0])
+	}
+	c.Zone = canonicalName(c.Zone)
+	return nil
+}
+
+// parse validates the prefix and derives the zone name if it is not configured.
+func (r *ReverseZone) parse() error {
+	if r.Prefix == "" {
+		if r.Zone == "" {
+			return fmt.Errorf("reverse zone: either prefix or zone must be set")
+		}
+		r.Zone = canonicalName(r.Zone)
+		return nil
+	}
+
+	prefix, err := netip.ParsePrefix(r.Prefix)
+	if err != nil {
+		return fmt.Errorf("reverse zone: %v", err)
+	}
+	r.prefix = prefix.Masked()
+
+	if r.Zone == "" {
+		r.Zone = reverseZoneName(r.prefix)
+		if r.Zone == "" {
+			return fmt.Errorf("reverse zone: %s is not on an octet or nibble boundary, zone must be set", r.Prefix)
+		}
+	}
+	r.Zone = canonicalName(r.Zone)
+	return nil
+}
+
+// containsString reports whether values contains value.
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
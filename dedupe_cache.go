@@ -0,0 +1,127 @@
+// dedupe_cache.go
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DedupeCache remembers the webhook deliveries accepted within the retention
+// window, so that retried or duplicated deliveries are not applied twice.
+type DedupeCache struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	retention time.Duration
+	lastPrune time.Time
+}
+
+// dedupeCache is the global cache of accepted webhook deliveries.
+var dedupeCache = &DedupeCache{seen: make(map[string]time.Time)}
+
+// initDedupeCache configures the retention window of the dedupe cache.
+func initDedupeCache(config *Config) {
+	dedupeCache.mu.Lock()
+	defer dedupeCache.mu.Unlock()
+	dedupeCache.retention = time.Duration(config.DedupeRetention) * time.Second
+}
+
+// deliveryKey identifies a delivery by its request ID, model, object ID, event
+// and timestamp. It returns an empty string for deliveries without a request
+// ID, which cannot be told apart from distinct changes.
+func deliveryKey(requestID, model string, objectID int, event, timestamp string) string {
+	if requestID == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s|%s|%d|%s|%s", requestID, model, objectID, event, timestamp)
+}
+
+// acceptDelivery claims a delivery in the dedupe cache. Duplicate deliveries
+// are acknowledged and logged with keyvals, and false is returned. Otherwise it
+// returns the ResponseWriter to handle the delivery with and a function to call
+// once it was handled, which forgets the delivery if it was rejected so that a
+// retry is processed.
+func acceptDelivery(w http.ResponseWriter, r *http.Request, key string, keyvals ...interface{}) (http.ResponseWriter, func(), bool) {
+	if key == "" {
+		return w, func() {}, true
+	}
+
+	if firstSeen, duplicate := dedupeCache.Claim(key); duplicate {
+		logInfo("Ignoring duplicate webhook delivery",
+			append(keyvals, "first_seen", firstSeen, "remote_addr", r.RemoteAddr)...,
+		)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Duplicate webhook delivery ignored"))
+		return w, nil, false
+	}
+	logDebug("Accepted webhook delivery", "key", key)
+
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	return rec, func() {
+		if rec.status >= http.StatusMultipleChoices {
+			dedupeCache.Forget(key)
+		}
+	}, true
+}
+
+// releaseDelivery forgets a delivery whose update failed after it was
+// acknowledged, so that a retry of the delivery is applied.
+func releaseDelivery(key string) {
+	if key == "" {
+		return
+	}
+	dedupeCache.Forget(key)
+	logDebug("Released failed webhook delivery", "key", key)
+}
+
+// Claim records a delivery. It reports whether the delivery was already
+// accepted within the retention window, and when it was first seen.
+func (c *DedupeCache) Claim(key string) (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.retention <= 0 || key == "" {
+		return time.Time{}, false
+	}
+
+	now := time.Now()
+	c.prune(now)
+	if firstSeen, exists := c.seen[key]; exists && now.Sub(firstSeen) < c.retention {
+		return firstSeen, true
+	}
+	c.seen[key] = now
+	return now, false
+}
+
+// Forget removes a delivery, so that a retry of a rejected delivery is processed.
+func (c *DedupeCache) Forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.seen, key)
+}
+
+// prune removes expired deliveries, at most once per minute.
+func (c *DedupeCache) prune(now time.Time) {
+	if now.Sub(c.lastPrune) < time.Minute {
+		return
+	}
+	c.lastPrune = now
+	for key, firstSeen := range c.seen {
+		if now.Sub(firstSeen) >= c.retention {
+			delete(c.seen, key)
+		}
+	}
+}
+
+// statusRecorder records the status code written to a ResponseWriter.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,137 @@
+// dns_query.go
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// ResourceRecord represents a single resource record returned by a DNS query.
+type ResourceRecord struct {
+	Name  string
+	TTL   int
+	Class string
+	Type  string
+	Value string
+}
+
+// DNSResponse represents the parts of a DNS response used by this service.
+type DNSResponse struct {
+	Status        string // Response code, e.g. NOERROR, NXDOMAIN, REFUSED
+	Authoritative bool   // AA flag
+	Answer        []ResourceRecord
+	Authority     []ResourceRecord
+}
+
+// ExecuteDig queries the DNS server for the given name and type and returns the answer section.
+// Queries are non-recursive and signed with the TSIG key used for updates.
+func ExecuteDig(name, recordType string, config *Config) ([]ResourceRecord, error) {
+	response, err := QueryDNS(name, recordType, config)
+	if err != nil {
+		return nil, err
+	}
+	return response.Answer, nil
+}
+
+// QueryDNS queries the DNS server for the given name and type and returns the
+// response code, the AA flag and the answer and authority sections.
+func QueryDNS(name, recordType string, config *Config) (*DNSResponse, error) {
+	cmd := exec.Command("dig",
+		"-k", config.TSIGKeyFile,
+		"@"+extractHost(config.BindServerAddress),
+		"-p", extractPort(config.BindServerAddress),
+		"+norecurse", "+noall", "+comments", "+answer", "+authority", "+time=2", "+tries=1",
+		name, recordType,
+	)
+
+	// Capture stdout and stderr for parsing and debugging
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("dig error: %v\nQUERY: %s %s\nstderr: %s", err, name, recordType, stderr.String())
+	}
+
+	return parseDigResponse(stdout.String()), nil
+}
+
+// parseDigResponse parses the output of dig +comments +answer +authority.
+func parseDigResponse(output string) *DNSResponse {
+	response := &DNSResponse{}
+	section := ""
+	var sectionLines []string
+	flush := func() {
+		records := parseDigAnswer(strings.Join(sectionLines, "\n"))
+		switch section {
+		case "ANSWER":
+			response.Answer = append(response.Answer, records...)
+		case "AUTHORITY":
+			response.Authority = append(response.Authority, records...)
+		}
+		sectionLines = nil
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, ";; ->>HEADER<<-"):
+			// ;; ->>HEADER<<- opcode: QUERY, status: NOERROR, id: 1234
+			if i := strings.Index(line, "status: "); i >= 0 {
+				response.Status = strings.TrimSuffix(strings.Fields(line[i+len("status: "):])[0], ",")
+			}
+		case strings.HasPrefix(line, ";; flags:"):
+			// ;; flags: qr aa; QUERY: 1, ANSWER: 1, AUTHORITY: 0, ADDITIONAL: 1
+			flags := strings.SplitN(strings.TrimPrefix(line, ";; flags:"), ";", 2)[0]
+			for _, flag := range strings.Fields(flags) {
+				if flag == "aa" {
+					response.Authoritative = true
+				}
+			}
+		case strings.HasPrefix(line, ";; ") && strings.HasSuffix(line, " SECTION:"):
+			flush()
+			section = strings.TrimSuffix(strings.TrimPrefix(line, ";; "), " SECTION:")
+		default:
+			sectionLines = append(sectionLines, line)
+		}
+	}
+	flush()
+
+	return response
+}
+
+// parseDigAnswer parses the answer section printed by dig into resource records.
+func parseDigAnswer(output string) []ResourceRecord {
+	var records []ResourceRecord
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		ttl, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+		records = append(records, ResourceRecord{
+			Name:  fields[0],
+			TTL:   ttl,
+			Class: fields[2],
+			Type:  strings.ToUpper(fields[3]),
+			Value: strings.Join(fields[4:], " "),
+		})
+	}
+	return records
+}
+
+// sameName reports whether two domain names are equal, ignoring case and the trailing dot.
+func sameName(a, b string) bool {
+	return strings.EqualFold(strings.TrimSuffix(a, "."), strings.TrimSuffix(b, "."))
+}
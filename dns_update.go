@@ -38,6 +38,35 @@ func ConstructNSUpdateScript(host, port, fqdn, recordType, oldValue, newValue, e
 	return script.String()
 }
 
+// ConstructRenameScript constructs the nsupdate script moving a record to a new
+// owner name or type. Within one zone the old record is deleted and the new
+// record added in a single update. An UPDATE message only covers one zone
+// (RFC 2136, section 3.4.1.3), so a record moved to another zone is added in
+// the new zone and then deleted in the old zone, in two updates.
+func ConstructRenameScript(host, port, oldFQDN, oldType, oldValue, newFQDN, newType, newValue string, ttl int, sameZone bool) string {
+	var script strings.Builder
+
+	// Specify the server
+	script.WriteString(fmt.Sprintf("server %s %s\n", host, port))
+
+	if ttl <= 0 {
+		ttl = 300
+	}
+	if !sameZone {
+		script.WriteString(fmt.Sprintf("update add %s %d IN %s %s\n", newFQDN, ttl, newType, newValue))
+		script.WriteString("send\n")
+		script.WriteString(fmt.Sprintf("update delete %s %s %s\n", oldFQDN, oldType, oldValue))
+		script.WriteString("send\n")
+		return script.String()
+	}
+	script.WriteString(fmt.Sprintf("update delete %s %s %s\n", oldFQDN, oldType, oldValue))
+	script.WriteString(fmt.Sprintf("update add %s %d IN %s %s\n", newFQDN, ttl, newType, newValue))
+	// Send the update
+	script.WriteString("send\n")
+
+	return script.String()
+}
+
 // ConstructPTRUpdateScript constructs the nsupdate script for PTR records.
 // This function also skips the 'zone' declaration.
 func ConstructPTRUpdateScript(host, port, ptrName, fqdn, event string, ttl int) string {
@@ -56,6 +85,83 @@ func ConstructPTRUpdateScript(host, port, ptrName, fqdn, event string, ttl int)
 		script.WriteString(fmt.Sprintf("update add %s %d IN PTR %s\n", ptrName, ttl, fqdn))
 	case "deleted":
 		script.WriteString(fmt.Sprintf("update delete %s PTR %s\n", ptrName, fqdn))
+	case "replaced":
+		// Replace the whole PTR RRset, overriding any auto-generated PTR
+		if ttl <= 0 {
+			ttl = 300
+		}
+		script.WriteString(fmt.Sprintf("update delete %s PTR\n", ptrName))
+		script.WriteString(fmt.Sprintf("update add %s %d IN PTR %s\n", ptrName, ttl, fqdn))
+	}
+	// Send the update
+	script.WriteString("send\n")
+
+	return script.String()
+}
+
+// ConstructPTRChangeScript constructs the nsupdate script changing the targets
+// of the PTR RRset at one owner name in a single update.
+func ConstructPTRChangeScript(host, port, ptrName string, remove, add []string, ttl int) string {
+	var script strings.Builder
+
+	// Specify the server
+	script.WriteString(fmt.Sprintf("server %s %s\n", host, port))
+
+	for _, target := range remove {
+		script.WriteString(fmt.Sprintf("update delete %s PTR %s\n", ptrName, target))
+	}
+	if ttl <= 0 {
+		ttl = 300
+	}
+	for _, target := range add {
+		script.WriteString(fmt.Sprintf("update add %s %d IN PTR %s\n", ptrName, ttl, target))
+	}
+	// Send the update
+	script.WriteString("send\n")
+
+	return script.String()
+}
+
+// ConstructCatalogMemberScript constructs the nsupdate script adding a member
+// zone to or removing it from a catalog zone (RFC 9432).
+func ConstructCatalogMemberScript(host, port, memberName, zoneName, event string) string {
+	var script strings.Builder
+
+	// Specify the server
+	script.WriteString(fmt.Sprintf("server %s %s\n", host, port))
+
+	switch event {
+	case "created":
+		// Catalog zones ignore the TTL of member records
+		script.WriteString(fmt.Sprintf("update delete %s PTR\n", memberName))
+		script.WriteString(fmt.Sprintf("update add %s 0 IN PTR %s\n", memberName, zoneName))
+	case "deleted":
+		script.WriteString(fmt.Sprintf("update delete %s PTR\n", memberName))
+	}
+	// Send the update
+	script.WriteString("send\n")
+
+	return script.String()
+}
+
+// ConstructZoneApexScript constructs the nsupdate script replacing the SOA and
+// changing the apex NS set of a zone in a single update. New nameservers are
+// added before old ones are removed so the zone never loses all NS records.
+func ConstructZoneApexScript(host, port, zoneName, soa string, soaTTL int, addNS, removeNS []string, nsTTL int) string {
+	var script strings.Builder
+
+	// Specify the server
+	script.WriteString(fmt.Sprintf("server %s %s\n", host, port))
+	script.WriteString(fmt.Sprintf("zone %s\n", zoneName))
+
+	if soa != "" {
+		script.WriteString(fmt.Sprintf("update add %s %d IN SOA %s\n", zoneName, soaTTL, soa))
+	}
+	for _, nameserver := range addNS {
+		script.WriteString(fmt.Sprintf("update add %s %d IN NS %s\n", zoneName, nsTTL, nameserver))
+	}
+	for _, nameserver := range removeNS {
+		script.WriteString(fmt.Sprintf("update delete %s NS %s\n", zoneName, nameserver))
 	}
 	// Send the update
 	script.WriteString("send\n")
@@ -7,21 +7,25 @@ import (
 	"strings"
 )
 
-// handleCreatedEvent processes "created" webhook events.
-func handleCreatedEvent(w http.ResponseWriter, config *Config, lockManager *RecordLockManager, payload *WebhookPayload) {
-	fqdn := payload.Data.FQDN
-	recordType := strings.ToUpper(payload.Data.Type)
-	value := payload.Data.Value
+// handleCreatedEvent processes "created" record changes.
+func handleCreatedEvent(w http.ResponseWriter, config *Config, lockManager *RecordLockManager, change *RecordChange) {
+	data := change.After
 
-	// Adjust value if record type is CNAME
+	fqdn := data.FQDN
+	recordType := strings.ToUpper(data.Type)
+	value := data.Value
+
+	// Adjust value if record type is CNAME or PTR
 	if recordType == "CNAME" {
-		value = adjustCNAMEValue(value, fqdn, payload.Data.Name)
+		value = adjustCNAMEValue(value, fqdn, data.Name)
+	} else if recordType == "PTR" {
+		value = qualifyPTRTarget(value, fqdn, data.Name)
 	}
 
-	// Extract TTL, default to 300 if nil or <=0
-	ttl := 300
-	if payload.Data.TTL != nil && *payload.Data.TTL > 0 {
-		ttl = *payload.Data.TTL
+	// Extract TTL, default to the zone's default TTL or 300 if nil or <=0
+	ttl := zoneCache.DefaultTTL(data.Zone.ID, 300)
+	if data.TTL != nil && *data.TTL > 0 {
+		ttl = *data.TTL
 	}
 
 	// Construct the nsupdate script to add the record
@@ -36,44 +40,95 @@ func handleCreatedEvent(w http.ResponseWriter, config *Config, lockManager *Reco
 		ttl,
 	)
 
+	// Explicit PTR records replace any auto-generated PTR at the same owner name
+	if recordType == "PTR" {
+		script = ConstructPTRUpdateScript(
+			extractHost(config.BindServerAddress),
+			extractPort(config.BindServerAddress),
+			fqdn,
+			value,
+			"replaced",
+			ttl,
+		)
+	}
+
 	logDebug("Outgoing nsupdate script for CREATED event", "script", script)
 
-	// Start a goroutine to handle the DNS update
-	go func() {
-		// Acquire lock for the FQDN
-		lockManager.AcquireLock(fqdn)
-		defer lockManager.ReleaseLock(fqdn)
+	// Reject changes that would violate the CNAME-and-other-data rule, taking
+	// the queued changes of the same request into account
+	if err := checkCNAMEConflict(fqdn, recordType, nil, updateBatcher.Scheduled(change.RequestID), config); err != nil {
+		logError(updateFailureMessage(err),
+			"fqdn", fqdn,
+			"err", err,
+			"event", "created",
+			"user", change.Username,
+			"request_id", change.RequestID,
+			"record_id", data.ID,
+		)
+		writeConflict(w, err)
+		return
+	}
 
-		// Execute nsupdate
-		err := ExecuteNSUpdate(script, config)
-		if err != nil {
-			logError("Failed to execute nsupdate",
-				"fqdn", fqdn,
-				"err", err,
+	// Queue the DNS update; bulk operations are batched by request ID
+	updateBatcher.Submit(change.RequestID, &updateJob{
+		Locks: []string{fqdn},
+		Zone:  data.Zone.Name,
+		// Check again with the lock held, as the record may have changed since
+		Check: func(scheduled *scheduledChanges) error {
+			return checkCNAMEConflict(fqdn, recordType, nil, scheduled, config)
+		},
+		Planned: script,
+		Script:  func() string { return script },
+		Done: func(err error, logProcessed logFunc) {
+			if err != nil {
+				logError(updateFailureMessage(err),
+					"fqdn", fqdn,
+					"err", err,
+					"event", "created",
+					"user", change.Username,
+					"request_id", change.RequestID,
+					"record_id", data.ID,
+				)
+				releaseDelivery(change.deliveryKey)
+				return
+			}
+
+			// Remember the record for zone lifecycle handling
+			if err := recordIndex.Put(ManagedRecord{
+				RecordID: data.ID,
+				ZoneID:   data.Zone.ID,
+				FQDN:     fqdn,
+				Type:     recordType,
+				Value:    value,
+				PTR:      hasPTR(data),
+			}); err != nil {
+				logError("Failed to persist record index", "err", err, "file", config.RecordIndexFile)
+			}
+
+			// The explicit PTR record now takes precedence over auto-generated PTRs
+			if recordType == "PTR" {
+				if err := explicitPTRs.SetExplicit(fqdn, value); err != nil {
+					logError("Failed to persist explicit PTR registry", "err", err, "file", config.ExplicitPTRFile)
+				}
+			}
+
+			// Log success
+			logProcessed("Processed DNS record",
 				"event", "created",
-				"user", payload.Username,
-				"request_id", payload.RequestID,
-				"record_id", payload.Data.ID,
+				"fqdn", fqdn,
+				"record_type", recordType,
+				"value", value,
+				"ttl", ttl,
+				"user", change.Username,
+				"request_id", change.RequestID,
+				"record_id", data.ID,
 			)
-			return
-		}
-
-		// Log success
-		logInfo("Processed DNS record",
-			"event", "created",
-			"fqdn", fqdn,
-			"record_type", recordType,
-			"value", value,
-			"ttl", ttl,
-			"user", payload.Username,
-			"request_id", payload.RequestID,
-			"record_id", payload.Data.ID,
-		)
-	}()
+		},
+	}, config, lockManager)
 
 	// Handle PTR records if needed and recordType is A or AAAA
-	if !payload.Data.DisablePTR && (recordType == "A" || recordType == "AAAA") {
-		handlePTRUpdate("created", nil, &payload.Data, config, lockManager)
+	if !data.DisablePTR && (recordType == "A" || recordType == "AAAA") {
+		handlePTRUpdate("created", ptrTransitionEnabled, change, nil, data, config, lockManager)
 	}
 
 	// Respond immediately
@@ -81,26 +136,29 @@ func handleCreatedEvent(w http.ResponseWriter, config *Config, lockManager *Reco
 	w.Write([]byte("Webhook received and is being processed"))
 }
 
-// handleDeletedEvent processes "deleted" webhook events.
-func handleDeletedEvent(w http.ResponseWriter, config *Config, lockManager *RecordLockManager, payload *WebhookPayload) {
-	// Check if Snapshots or PreChange is missing
-	if payload.Snapshots == nil || payload.Snapshots.PreChange == nil {
-		logError("Snapshots missing or incomplete in payload",
-			"record_id", payload.Data.ID,
+// handleDeletedEvent processes "deleted" record changes.
+func handleDeletedEvent(w http.ResponseWriter, config *Config, lockManager *RecordLockManager, change *RecordChange) {
+	// Check if the record state before the change is missing
+	if change.Before == nil {
+		logError("Record state before the change missing",
+			"source", change.Source,
+			"request_id", change.RequestID,
 		)
-		http.Error(w, "Snapshots missing in payload", http.StatusBadRequest)
+		writeProblem(w, http.StatusBadRequest, "Record state before the change missing")
 		return
 	}
 
-	preChange := payload.Snapshots.PreChange
+	preChange := change.Before
 
 	fqdn := preChange.FQDN
 	recordType := strings.ToUpper(preChange.Type)
 	value := preChange.Value
 
-	// Adjust value if record type is CNAME
+	// Adjust value if record type is CNAME or PTR
 	if recordType == "CNAME" {
 		value = adjustCNAMEValue(value, fqdn, preChange.Name)
+	} else if recordType == "PTR" {
+		value = qualifyPTRTarget(value, fqdn, preChange.Name)
 	}
 
 	// Construct the nsupdate script to delete the record
@@ -117,43 +175,72 @@ func handleDeletedEvent(w http.ResponseWriter, config *Config, lockManager *Reco
 
 	logDebug("Outgoing nsupdate script for DELETED event", "script", script)
 
-	// Start a goroutine to handle the DNS update
-	go func() {
-		// Acquire lock for the FQDN
-		lockManager.AcquireLock(fqdn)
-		defer lockManager.ReleaseLock(fqdn)
-
-		// Execute nsupdate
-		err := ExecuteNSUpdate(script, config)
-		if err != nil {
-			logError("Failed to execute nsupdate",
-				"fqdn", fqdn,
-				"err", err,
+	// Queue the DNS update; bulk operations are batched by request ID
+	updateBatcher.Submit(change.RequestID, &updateJob{
+		Locks:   []string{fqdn},
+		Zone:    preChange.Zone.Name,
+		Planned: script,
+		// Restore the auto-generated PTR targets once the explicit PTR record
+		// is gone; the targets are read with the lock held, as PTR updates of
+		// forward records queued before may still change them
+		Script: func() string {
+			if recordType != "PTR" || config.PTRSource != PTRSourceService {
+				return script
+			}
+			targets, targetTTL := ptrIndex.Targets(fqdn, config.PTRPrimaryPolicy)
+			if len(targets) == 0 {
+				return script
+			}
+			return script + ConstructPTRChangeScript(
+				extractHost(config.BindServerAddress),
+				extractPort(config.BindServerAddress),
+				fqdn,
+				nil,
+				targets,
+				ptrTTL(fqdn, targetTTL, config),
+			)
+		},
+		Done: func(err error, logProcessed logFunc) {
+			if err != nil {
+				logError("Failed to execute nsupdate",
+					"fqdn", fqdn,
+					"err", err,
+					"event", "deleted",
+					"user", change.Username,
+					"request_id", change.RequestID,
+					"record_id", preChange.ID,
+				)
+				releaseDelivery(change.deliveryKey)
+				return
+			}
+
+			// Forget the record
+			if err := recordIndex.Remove(preChange.ID); err != nil {
+				logError("Failed to persist record index", "err", err, "file", config.RecordIndexFile)
+			}
+			if recordType == "PTR" {
+				if err := explicitPTRs.RemoveExplicit(fqdn); err != nil {
+					logError("Failed to persist explicit PTR registry", "err", err, "file", config.ExplicitPTRFile)
+				}
+			}
+
+			// Log success
+			logProcessed("Processed DNS record",
 				"event", "deleted",
-				"user", payload.Username,
-				"request_id", payload.RequestID,
+				"fqdn", fqdn,
+				"record_type", recordType,
+				"value", value,
+				"ttl", 0,
+				"user", change.Username,
+				"request_id", change.RequestID,
 				"record_id", preChange.ID,
 			)
-			return
-		}
-
-		// Log success
-		logInfo("Processed DNS record",
-			"event", "deleted",
-			"fqdn", fqdn,
-			"record_type", recordType,
-			"value", value,
-			"ttl", 0,
-			"user", payload.Username,
-			"request_id", payload.RequestID,
-			"record_id", preChange.ID,
-		)
-	}()
+		},
+	}, config, lockManager)
 
 	// Handle PTR records if needed and recordType is A or AAAA
 	if !preChange.DisablePTR && (recordType == "A" || recordType == "AAAA") {
-		preData := snapshotToRecordData(preChange)
-		handlePTRUpdate("deleted", preData, nil, config, lockManager)
+		handlePTRUpdate("deleted", ptrTransitionDisabled, change, preChange, nil, config, lockManager)
 	}
 
 	// Respond immediately
@@ -161,43 +248,58 @@ func handleDeletedEvent(w http.ResponseWriter, config *Config, lockManager *Reco
 	w.Write([]byte("Webhook received and is being processed"))
 }
 
-// handleUpdatedEvent processes "updated" webhook events.
-func handleUpdatedEvent(w http.ResponseWriter, config *Config, lockManager *RecordLockManager, payload *WebhookPayload) {
-	// Check if Snapshots or PostChange is missing
-	if payload.Snapshots == nil || payload.Snapshots.PostChange == nil || payload.Snapshots.PostChange.FQDN == "" {
-		logError("Snapshots missing or incomplete in payload",
-			"record_id", payload.Data.ID,
+// handleUpdatedEvent processes "updated" record changes.
+func handleUpdatedEvent(w http.ResponseWriter, config *Config, lockManager *RecordLockManager, change *RecordChange) {
+	// Check if the record state after the change is missing
+	if change.After == nil || change.After.FQDN == "" {
+		logError("Record state after the change missing",
+			"source", change.Source,
+			"request_id", change.RequestID,
 		)
-		http.Error(w, "Snapshots missing in payload", http.StatusBadRequest)
+		writeProblem(w, http.StatusBadRequest, "Record state after the change missing")
 		return
 	}
 
-	preChange := payload.Snapshots.PreChange
-	postChange := payload.Snapshots.PostChange
+	preChange := change.Before
+	postChange := change.After
 
 	fqdn := postChange.FQDN
 	recordType := strings.ToUpper(postChange.Type)
 	newValue := postChange.Value
 
-	// Extract TTL, default to 300 if nil or <=0
-	ttl := 300
+	// Extract TTL, default to the zone's default TTL or 300 if nil or <=0
+	ttl := zoneCache.DefaultTTL(postChange.Zone.ID, 300)
 	if postChange.TTL != nil && *postChange.TTL > 0 {
 		ttl = *postChange.TTL
 	}
 
-	// Determine old value
+	// Determine old owner name, type and value
+	oldFQDN := fqdn
+	oldType := recordType
 	oldValue := ""
 	if preChange != nil {
+		oldFQDN = preChange.FQDN
+		oldType = strings.ToUpper(preChange.Type)
 		oldValue = preChange.Value
 	}
+	renamed := !sameName(oldFQDN, fqdn)
 
-	// Adjust values if record type is CNAME
+	// Adjust values if record type is CNAME or PTR
 	if recordType == "CNAME" {
 		newValue = adjustCNAMEValue(newValue, fqdn, postChange.Name)
-		if oldValue != "" && preChange != nil {
-			oldFQDN := preChange.FQDN
-			oldValue = adjustCNAMEValue(oldValue, oldFQDN, preChange.Name)
-		}
+	} else if recordType == "PTR" {
+		newValue = qualifyPTRTarget(newValue, fqdn, postChange.Name)
+	}
+	if oldValue != "" && oldType == "CNAME" {
+		oldValue = adjustCNAMEValue(oldValue, oldFQDN, preChange.Name)
+	} else if oldValue != "" && oldType == "PTR" {
+		oldValue = qualifyPTRTarget(oldValue, oldFQDN, preChange.Name)
+	}
+
+	// The old record is removed by the same update if the owner name is unchanged
+	var replaced *ResourceRecord
+	if preChange != nil && !renamed {
+		replaced = &ResourceRecord{Name: oldFQDN, Type: oldType, Value: oldValue}
 	}
 
 	// Construct the nsupdate script
@@ -212,59 +314,168 @@ func handleUpdatedEvent(w http.ResponseWriter, config *Config, lockManager *Reco
 		ttl,
 	)
 
-	logDebug("Outgoing nsupdate script for UPDATED event", "script", script)
-
-	// Start a goroutine to handle the DNS update
-	go func() {
-		// Acquire lock for the FQDN
-		lockManager.AcquireLock(fqdn)
-		defer lockManager.ReleaseLock(fqdn)
+	// Records moved to another zone are updated in two zones
+	movedZone := preChange != nil && preChange.Zone.ID != postChange.Zone.ID
+
+	// Renamed records and type changes are removed at the old owner name and type
+	if preChange != nil && (renamed || oldType != recordType) {
+		script = ConstructRenameScript(
+			extractHost(config.BindServerAddress),
+			extractPort(config.BindServerAddress),
+			oldFQDN,
+			oldType,
+			oldValue,
+			fqdn,
+			recordType,
+			newValue,
+			ttl,
+			!movedZone,
+		)
+	}
 
-		// Execute nsupdate
-		err := ExecuteNSUpdate(script, config)
-		if err != nil {
-			logError("Failed to execute nsupdate",
-				"fqdn", fqdn,
-				"err", err,
-				"event", "updated",
-				"user", payload.Username,
-				"request_id", payload.RequestID,
-				"record_id", payload.Data.ID,
+	// Explicit PTR records replace any auto-generated PTR at the new owner name
+	if recordType == "PTR" {
+		script = ""
+		if preChange != nil && oldValue != "" {
+			script += ConstructPTRUpdateScript(
+				extractHost(config.BindServerAddress),
+				extractPort(config.BindServerAddress),
+				oldFQDN,
+				oldValue,
+				"deleted",
+				0,
 			)
-			return
 		}
-
-		// Log success
-		logInfo("Processed DNS record",
-			"event", "updated",
-			"fqdn", fqdn,
-			"record_type", recordType,
-			"old_value", oldValue,
-			"new_value", newValue,
-			"ttl", ttl,
-			"user", payload.Username,
-			"request_id", payload.RequestID,
-			"record_id", payload.Data.ID,
+		script += ConstructPTRUpdateScript(
+			extractHost(config.BindServerAddress),
+			extractPort(config.BindServerAddress),
+			fqdn,
+			newValue,
+			"replaced",
+			ttl,
 		)
-	}()
+	}
 
-	// Convert snapshots to RecordData
-	preData := snapshotToRecordData(preChange)
-	postData := snapshotToRecordData(postChange)
+	logDebug("Outgoing nsupdate script for UPDATED event", "script", script)
 
-	// Handle PTR records if needed and recordType is A or AAAA
-	if recordType == "A" || recordType == "AAAA" {
-		preDisablePTR := false
-		postDisablePTR := postData.DisablePTR
+	// The updates of records moved to another zone are never merged into a batch message
+	zone := postChange.Zone.Name
+	if movedZone {
+		zone = ""
+	}
 
-		if preData != nil {
-			preDisablePTR = preData.DisablePTR
-		}
+	// Reject changes that would violate the CNAME-and-other-data rule, taking
+	// the queued changes of the same request into account
+	if err := checkCNAMEConflict(fqdn, recordType, replaced, updateBatcher.Scheduled(change.RequestID), config); err != nil {
+		logError(updateFailureMessage(err),
+			"fqdn", fqdn,
+			"old_fqdn", oldFQDN,
+			"err", err,
+			"event", "updated",
+			"user", change.Username,
+			"request_id", change.RequestID,
+			"record_id", postChange.ID,
+		)
+		writeConflict(w, err)
+		return
+	}
 
-		// Handle PTR updates accordingly
-		if preDisablePTR != postDisablePTR || !postDisablePTR {
-			handlePTRUpdate("updated", preData, postData, config, lockManager)
-		}
+	// Queue the DNS update holding the locks of the old and new FQDN
+	updateBatcher.Submit(change.RequestID, &updateJob{
+		Locks: []string{oldFQDN, fqdn},
+		Zone:  zone,
+		// Check again with the locks held, as the records may have changed since
+		Check: func(scheduled *scheduledChanges) error {
+			return checkCNAMEConflict(fqdn, recordType, replaced, scheduled, config)
+		},
+		Planned: script,
+		Script:  func() string { return script },
+		Done: func(err error, logProcessed logFunc) {
+			if err != nil {
+				logError(updateFailureMessage(err),
+					"fqdn", fqdn,
+					"old_fqdn", oldFQDN,
+					"err", err,
+					"event", "updated",
+					"user", change.Username,
+					"request_id", change.RequestID,
+					"record_id", postChange.ID,
+				)
+				releaseDelivery(change.deliveryKey)
+				return
+			}
+
+			// Remember the record for zone lifecycle handling
+			if err := recordIndex.Put(ManagedRecord{
+				RecordID: postChange.ID,
+				ZoneID:   postChange.Zone.ID,
+				FQDN:     fqdn,
+				Type:     recordType,
+				Value:    newValue,
+				PTR:      hasPTR(postChange),
+			}); err != nil {
+				logError("Failed to persist record index", "err", err, "file", config.RecordIndexFile)
+			}
+			// Synthetic record IDs change with the owner name
+			if preChange != nil && preChange.ID != postChange.ID {
+				if err := recordIndex.Remove(preChange.ID); err != nil {
+					logError("Failed to persist record index", "err", err, "file", config.RecordIndexFile)
+				}
+			}
+
+			// Move the explicit PTR record to its new owner name
+			if recordType == "PTR" {
+				if preChange != nil && oldValue != "" {
+					if err := explicitPTRs.RemoveExplicit(oldFQDN); err != nil {
+						logError("Failed to persist explicit PTR registry", "err", err, "file", config.ExplicitPTRFile)
+					}
+				}
+				if err := explicitPTRs.SetExplicit(fqdn, newValue); err != nil {
+					logError("Failed to persist explicit PTR registry", "err", err, "file", config.ExplicitPTRFile)
+				}
+			}
+
+			// Log success
+			if renamed {
+				logProcessed("Renamed DNS record",
+					"event", "updated",
+					"old_fqdn", oldFQDN,
+					"new_fqdn", fqdn,
+					"old_record_type", oldType,
+					"record_type", recordType,
+					"old_value", oldValue,
+					"new_value", newValue,
+					"ttl", ttl,
+					"user", change.Username,
+					"request_id", change.RequestID,
+					"record_id", postChange.ID,
+				)
+				return
+			}
+			logProcessed("Processed DNS record",
+				"event", "updated",
+				"fqdn", fqdn,
+				"record_type", recordType,
+				"old_value", oldValue,
+				"new_value", newValue,
+				"ttl", ttl,
+				"user", change.Username,
+				"request_id", change.RequestID,
+				"record_id", postChange.ID,
+			)
+		},
+	}, config, lockManager)
+
+	// Emit only the PTR changes required by the transition between the snapshots
+	transition := classifyPTRTransition(preChange, postChange)
+	logDebug("Determined PTR transition",
+		"transition", transition,
+		"fqdn", fqdn,
+		"request_id", change.RequestID,
+		"record_id", postChange.ID,
+	)
+	if transition != ptrTransitionNone && transition != ptrTransitionUnchanged {
+		handlePTRUpdate("updated", transition, change, ptrSide(preChange), ptrSide(postChange), config, lockManager)
 	}
 
 	// Respond immediately
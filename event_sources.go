@@ -0,0 +1,67 @@
+// event_sources.go
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Types of event sources.
+const (
+	// EventSourceNetBox accepts NetBox DNS webhooks.
+	EventSourceNetBox = "netbox"
+	// EventSourceKea accepts Kea DHCP lease hook events.
+	EventSourceKea = "kea"
+	// EventSourceGeneric accepts record changes in the generic JSON change format.
+	EventSourceGeneric = "generic"
+)
+
+// EventSource configures a change source besides the NetBox DNS webhooks on /webhook.
+type EventSource struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // EventSourceNetBox, EventSourceKea or EventSourceGeneric
+	Path string `json:"path"` // URL path the source is served on, defaults to /events/<name>
+
+	// Domain qualifies unqualified lease hostnames (Kea).
+	Domain string `json:"domain"`
+	// Zone is the zone of the lease records (Kea).
+	Zone string `json:"zone"`
+	// TTL is the TTL of the lease records, 0 uses the zone default (Kea).
+	TTL int `json:"ttl"`
+}
+
+// parse validates the event source.
+func (s *EventSource) parse() error {
+	if s.Name == "" {
+		return fmt.Errorf("event source without name")
+	}
+	s.Type = strings.ToLower(s.Type)
+	switch s.Type {
+	case EventSourceNetBox, EventSourceKea, EventSourceGeneric:
+	default:
+		return fmt.Errorf("event source %s: unknown type %q", s.Name, s.Type)
+	}
+	if s.Path == "" {
+		s.Path = "/events/" + s.Name
+	}
+	if !strings.HasPrefix(s.Path, "/") {
+		return fmt.Errorf("event source %s: path %q must start with /", s.Name, s.Path)
+	}
+	switch s.Path {
+	case "/webhook", "/healthz", "/ready":
+		return fmt.Errorf("event source %s: path %s is reserved", s.Name, s.Path)
+	}
+	return nil
+}
+
+// newSourceAdapter returns the adapter of an event source.
+func newSourceAdapter(source EventSource) SourceAdapter {
+	switch source.Type {
+	case EventSourceKea:
+		return &keaAdapter{source: source}
+	case EventSourceGeneric:
+		return &genericAdapter{name: source.Name}
+	}
+	return netBoxAdapter{source: source.Name}
+}
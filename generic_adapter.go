@@ -0,0 +1,99 @@
+// generic_adapter.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// genericChange is a record change in the generic JSON change format.
+type genericChange struct {
+	Event     string         `json:"event"`
+	RequestID string         `json:"request_id"`
+	Username  string         `json:"username"`
+	Timestamp string         `json:"timestamp"`
+	Before    *genericRecord `json:"before"`
+	After     *genericRecord `json:"after"`
+}
+
+// genericRecord is a record in the generic JSON change format.
+type genericRecord struct {
+	ID         int    `json:"id"`
+	FQDN       string `json:"fqdn"`
+	Type       string `json:"type"`
+	Value      string `json:"value"`
+	TTL        *int   `json:"ttl"`
+	DisablePTR bool   `json:"disable_ptr"`
+	Zone       string `json:"zone"`
+	ZoneID     int    `json:"zone_id"`
+}
+
+// genericAdapter decodes record changes in the generic JSON change format:
+// a single change or a JSON array of changes.
+type genericAdapter struct {
+	name string
+}
+
+// Name implements SourceAdapter.
+func (a *genericAdapter) Name() string { return a.name }
+
+// Decode implements SourceAdapter.
+func (a *genericAdapter) Decode(body []byte) ([]*SourceChange, error) {
+	var generic []genericChange
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &generic); err != nil {
+			return nil, fmt.Errorf("invalid JSON payload: %v", err)
+		}
+	} else {
+		var single genericChange
+		if err := json.Unmarshal(body, &single); err != nil {
+			return nil, fmt.Errorf("invalid JSON payload: %v", err)
+		}
+		generic = append(generic, single)
+	}
+
+	changes := make([]*SourceChange, 0, len(generic))
+	for _, g := range generic {
+		// A record keeps its ID when only one side of the change names it
+		if g.Before != nil && g.After != nil {
+			if g.Before.ID == 0 {
+				g.Before.ID = g.After.ID
+			} else if g.After.ID == 0 {
+				g.After.ID = g.Before.ID
+			}
+		}
+		changes = append(changes, &SourceChange{Record: &RecordChange{
+			Source:    a.name,
+			Event:     normalizeEvent(g.Event),
+			RequestID: g.RequestID,
+			Username:  g.Username,
+			Timestamp: g.Timestamp,
+			Before:    a.recordData(g.Before),
+			After:     a.recordData(g.After),
+		}})
+	}
+	return changes, nil
+}
+
+// recordData converts a generic record; records without ID get a synthetic ID.
+func (a *genericAdapter) recordData(r *genericRecord) *RecordData {
+	if r == nil {
+		return nil
+	}
+	id := r.ID
+	if id == 0 {
+		id = syntheticRecordID(a.name, r.FQDN, strings.ToUpper(r.Type))
+	}
+	return &RecordData{
+		ID:         id,
+		FQDN:       r.FQDN,
+		Type:       r.Type,
+		Value:      r.Value,
+		TTL:        r.TTL,
+		DisablePTR: r.DisablePTR,
+		Zone:       ZoneData{ID: r.ZoneID, Name: r.Zone},
+	}
+}
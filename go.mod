@@ -2,6 +2,12 @@ module dnsupdate_webhook
 
 go 1.23
 
-require github.com/go-kit/log v0.2.1
+require (
+	github.com/go-kit/log v0.2.1
+	golang.org/x/net v0.35.0
+)
 
-require github.com/go-logfmt/logfmt v0.5.1 // indirect
+require (
+	github.com/go-logfmt/logfmt v0.5.1 // indirect
+	golang.org/x/text v0.22.0 // indirect
+)
@@ -3,8 +3,13 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
 	"strings"
 )
 
@@ -29,30 +34,27 @@ func extractPort(address string) string {
 }
 
 // reverseDNSName computes the reverse DNS name for an IP address.
+// IPv4-mapped IPv6 addresses keep their ip6.arpa name; callers decide whether to unmap them.
 func reverseDNSName(ip string) string {
-	parsedIP := net.ParseIP(ip)
-	if parsedIP == nil {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
 		return ""
 	}
 
-	if parsedIP.To4() != nil {
+	if addr.Is4() {
 		// IPv4
-		octets := strings.Split(parsedIP.String(), ".")
-		reverseOctets := []string{octets[3], octets[2], octets[1], octets[0]}
-		return strings.Join(reverseOctets, ".") + ".in-addr.arpa."
-	} else {
-		// IPv6
-		expandedIP := parsedIP.To16()
-		if expandedIP == nil {
-			return ""
-		}
-		var nibbles []string
-		for i := len(expandedIP) - 1; i >= 0; i-- {
-			nibbles = append(nibbles, fmt.Sprintf("%x", expandedIP[i]&0x0F))
-			nibbles = append(nibbles, fmt.Sprintf("%x", (expandedIP[i]>>4)&0x0F))
-		}
-		return strings.Join(nibbles, ".") + ".ip6.arpa."
+		octets := addr.As4()
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", octets[3], octets[2], octets[1], octets[0])
+	}
+
+	// IPv6
+	expandedIP := addr.As16()
+	var nibbles []string
+	for i := len(expandedIP) - 1; i >= 0; i-- {
+		nibbles = append(nibbles, fmt.Sprintf("%x", expandedIP[i]&0x0F))
+		nibbles = append(nibbles, fmt.Sprintf("%x", (expandedIP[i]>>4)&0x0F))
 	}
+	return strings.Join(nibbles, ".") + ".ip6.arpa."
 }
 
 // isValidIP checks if the provided string is a valid IP address.
@@ -73,7 +75,10 @@ func snapshotToRecordData(s *Snapshot) *RecordData {
 		Value:      s.Value,
 		TTL:        s.TTL,
 		DisablePTR: s.DisablePTR,
+		Managed:    s.Managed,
 		Zone:       ZoneData{ID: s.Zone},
+
+		CustomFields: s.CustomFields,
 	}
 }
 
@@ -122,3 +127,28 @@ func getZoneNameFromFQDN(fqdn, recordName string) string {
 	// If recordName not found, return fqdn
 	return fqdn
 }
+
+// writeFileAtomic writes data to a temporary file and renames it to path,
+// so a crash never leaves a truncated file behind.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// newJobID returns a random identifier for an auditable job.
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,135 @@
+// idna.go
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// idnaProfile converts names using IDNA2008 with the UTS#46 lookup mapping.
+// StrictDomainName is disabled so that underscore labels (e.g. _sip._tcp) and
+// wildcard labels are passed through unchanged.
+var idnaProfile = idna.New(
+	idna.MapForLookup(),
+	idna.BidiRule(),
+	idna.Transitional(false),
+	idna.StrictDomainName(false),
+)
+
+// toASCIIName converts a domain name to its ASCII (punycode) form.
+// Plain ASCII names without A-labels are returned unchanged.
+func toASCIIName(name string) (string, error) {
+	if name == "" || (isASCII(name) && !strings.Contains(strings.ToLower(name), "xn--")) {
+		return name, nil
+	}
+
+	// Preserve the trailing dot of fully qualified names
+	trailingDot := strings.HasSuffix(name, ".")
+	ascii, err := idnaProfile.ToASCII(strings.TrimSuffix(name, "."))
+	if err != nil {
+		return "", fmt.Errorf("invalid domain name %q: %v", name, err)
+	}
+	if trailingDot {
+		ascii += "."
+	}
+	return ascii, nil
+}
+
+// toASCIIValue converts the domain names carried in the RDATA of a record.
+// Values of record types that do not carry domain names are returned unchanged.
+func toASCIIValue(recordType, value string) (string, error) {
+	// Index of the field holding the domain name in the RDATA
+	nameField := -1
+	switch strings.ToUpper(recordType) {
+	case "CNAME", "DNAME", "NS", "PTR":
+		nameField = 0
+	case "MX":
+		nameField = 1
+	case "SRV":
+		nameField = 3
+	}
+	if nameField < 0 {
+		return value, nil
+	}
+
+	fields := strings.Fields(value)
+	if len(fields) <= nameField {
+		return value, nil
+	}
+	ascii, err := toASCIIName(fields[nameField])
+	if err != nil {
+		return "", err
+	}
+	if ascii == fields[nameField] {
+		return value, nil
+	}
+	fields[nameField] = ascii
+	return strings.Join(fields, " "), nil
+}
+
+// normalizeIDN converts all owner names and name-bearing RDATA in the payload
+// to their ASCII form, logging both forms for every converted name.
+func normalizeIDN(payload *WebhookPayload) error {
+	if err := normalizeRecordIDN("data", &payload.Data.FQDN, &payload.Data.Name, &payload.Data.Value, payload.Data.Type); err != nil {
+		return err
+	}
+	if err := convertIDN("data.zone.name", &payload.Data.Zone.Name, toASCIIName); err != nil {
+		return err
+	}
+	if payload.Snapshots != nil {
+		if s := payload.Snapshots.PreChange; s != nil {
+			if err := normalizeRecordIDN("snapshots.prechange", &s.FQDN, &s.Name, &s.Value, s.Type); err != nil {
+				return err
+			}
+		}
+		if s := payload.Snapshots.PostChange; s != nil {
+			if err := normalizeRecordIDN("snapshots.postchange", &s.FQDN, &s.Name, &s.Value, s.Type); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// normalizeRecordIDN converts the names of a single record in place.
+func normalizeRecordIDN(prefix string, fqdn, name, value *string, recordType string) error {
+	if err := convertIDN(prefix+".fqdn", fqdn, toASCIIName); err != nil {
+		return err
+	}
+	if err := convertIDN(prefix+".name", name, toASCIIName); err != nil {
+		return err
+	}
+	return convertIDN(prefix+".value", value, func(v string) (string, error) {
+		return toASCIIValue(recordType, v)
+	})
+}
+
+// convertIDN applies convert to the field and logs the Unicode and ASCII forms if they differ.
+func convertIDN(field string, target *string, convert func(string) (string, error)) error {
+	ascii, err := convert(*target)
+	if err != nil {
+		return fmt.Errorf("%s: %v", field, err)
+	}
+	if ascii != *target {
+		logInfo("Converted internationalized domain name",
+			"field", field,
+			"unicode", *target,
+			"ascii", ascii,
+		)
+		*target = ascii
+	}
+	return nil
+}
+
+// isASCII reports whether s contains only ASCII characters.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,163 @@
+// kea_adapter.go
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// keaLeaseEvents maps Kea lease hook points to record change events. Committed,
+// renewed and recovered leases replace the address records of their hostname;
+// released, expired and declined leases remove them.
+var keaLeaseEvents = map[string]string{
+	"leases4_committed": "updated",
+	"leases6_committed": "updated",
+	"lease4_renew":      "updated",
+	"lease6_renew":      "updated",
+	"lease6_rebind":     "updated",
+	"lease4_recover":    "updated",
+	"lease6_recover":    "updated",
+	"lease4_release":    "deleted",
+	"lease6_release":    "deleted",
+	"lease4_expire":     "deleted",
+	"lease6_expire":     "deleted",
+	"lease4_decline":    "deleted",
+	"lease6_decline":    "deleted",
+}
+
+// keaLeaseEvent is the body sent by a Kea lease hook, e.g. from a run_script
+// hook script. It carries a single lease or, for committed leases, a list.
+type keaLeaseEvent struct {
+	Event     string     `json:"event"`
+	RequestID string     `json:"request_id"`
+	Timestamp string     `json:"timestamp"`
+	Lease     *keaLease  `json:"lease"`
+	Leases    []keaLease `json:"leases"`
+}
+
+// keaLease holds the lease fields used by the adapter, named as in the Kea lease commands.
+type keaLease struct {
+	IPAddress string `json:"ip-address"`
+	Hostname  string `json:"hostname"`
+	Type      string `json:"type"` // IA_NA, IA_TA or IA_PD for DHCPv6 leases
+	FQDNFwd   *bool  `json:"fqdn-fwd"`
+	FQDNRev   *bool  `json:"fqdn-rev"`
+}
+
+// keaAdapter decodes Kea DHCP lease hook events into record changes.
+type keaAdapter struct {
+	source EventSource
+}
+
+// Name implements SourceAdapter.
+func (a *keaAdapter) Name() string { return a.source.Name }
+
+// Decode implements SourceAdapter. Leases without hostname, prefix delegations
+// and leases whose forward update is disabled yield no record change.
+func (a *keaAdapter) Decode(body []byte) ([]*SourceChange, error) {
+	var event keaLeaseEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("invalid JSON payload: %v", err)
+	}
+
+	changeEvent, known := keaLeaseEvents[strings.ToLower(event.Event)]
+	if !known {
+		logDebug("Ignoring unsupported Kea lease event", "source", a.Name(), "event", event.Event)
+		return nil, nil
+	}
+
+	leases := event.Leases
+	if event.Lease != nil {
+		leases = append(leases, *event.Lease)
+	}
+
+	var changes []*SourceChange
+	for _, lease := range leases {
+		data, reason := a.leaseRecord(lease)
+		if data == nil {
+			logDebug("Skipping Kea lease", "source", a.Name(), "event", event.Event, "ip", lease.IPAddress, "reason", reason)
+			continue
+		}
+
+		change := &RecordChange{
+			Source:    a.Name(),
+			Event:     changeEvent,
+			RequestID: event.RequestID,
+			Timestamp: event.Timestamp,
+		}
+		if changeEvent == "deleted" {
+			change.Before = data
+		} else {
+			// The address applied before is replaced, so that a lease moving
+			// to another address releases the PTR record of the old one
+			change.Before = a.previousRecord(data)
+			change.After = data
+		}
+		changes = append(changes, &SourceChange{Record: change})
+	}
+	return changes, nil
+}
+
+// previousRecord returns the record last applied for the synthetic ID of a
+// lease record, or nil if the record is not managed yet.
+func (a *keaAdapter) previousRecord(data *RecordData) *RecordData {
+	record, exists := recordIndex.Get(data.ID)
+	if !exists {
+		return nil
+	}
+	return &RecordData{
+		ID:         record.RecordID,
+		FQDN:       record.FQDN,
+		Type:       record.Type,
+		Value:      record.Value,
+		DisablePTR: !record.PTR,
+		Zone:       ZoneData{ID: record.ZoneID, Name: a.source.Zone},
+	}
+}
+
+// leaseRecord returns the address record of a lease, or nil and the reason the lease has none.
+func (a *keaAdapter) leaseRecord(lease keaLease) (*RecordData, string) {
+	if strings.EqualFold(lease.Type, "IA_PD") {
+		return nil, "prefix delegation"
+	}
+	if lease.FQDNFwd != nil && !*lease.FQDNFwd {
+		return nil, "forward update disabled"
+	}
+	hostname := strings.TrimSpace(lease.Hostname)
+	if hostname == "" {
+		return nil, "no hostname"
+	}
+	if !strings.Contains(strings.TrimSuffix(hostname, "."), ".") {
+		if a.source.Domain == "" {
+			return nil, "unqualified hostname"
+		}
+		hostname += "." + strings.Trim(a.source.Domain, ".")
+	}
+	fqdn := canonicalName(hostname)
+
+	addr, err := netip.ParseAddr(lease.IPAddress)
+	if err != nil {
+		return nil, "invalid address"
+	}
+	recordType := "A"
+	if addr.Is6() {
+		recordType = "AAAA"
+	}
+
+	data := &RecordData{
+		ID:         syntheticRecordID(a.Name(), fqdn, recordType),
+		FQDN:       fqdn,
+		Type:       recordType,
+		Value:      addr.String(),
+		DisablePTR: lease.FQDNRev != nil && !*lease.FQDNRev,
+		Zone:       ZoneData{Name: a.source.Zone},
+	}
+	if a.source.TTL > 0 {
+		ttl := a.source.TTL
+		data.TTL = &ttl
+	}
+	return data, ""
+}
@@ -6,6 +6,7 @@ import (
 	"flag"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
@@ -38,20 +39,95 @@ func main() {
 	// Initialize logger
 	initLogger(config)
 
+	if config.WebhookSecret == "" {
+		logWarn("No webhook secret configured, webhook signatures are not verified")
+	}
+
+	// Load the PTR ownership index
+	if err := initPTRIndex(config); err != nil {
+		logError("Failed to load PTR index", "err", err)
+		os.Exit(1)
+	}
+
+	// Load the registry of explicit PTR records
+	if err := initPTRRegistry(config); err != nil {
+		logError("Failed to load explicit PTR registry", "err", err)
+		os.Exit(1)
+	}
+
+	// Load the index of managed records
+	if err := initRecordIndex(config); err != nil {
+		logError("Failed to load record index", "err", err)
+		os.Exit(1)
+	}
+
+	// Remember accepted deliveries to acknowledge duplicates
+	initDedupeCache(config)
+
+	// Prepare the replay protection
+	if err := initReplayGuard(config); err != nil {
+		logError("Replay protection configuration error", "err", err)
+		os.Exit(1)
+	}
+
 	// Initialize the RecordLockManager
 	lockManager := &RecordLockManager{}
 
 	// Register HTTP handlers
-	http.HandleFunc("/webhook", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/webhook", allowSources("/webhook", config, requireClientCert(config, func(w http.ResponseWriter, r *http.Request) {
 		webhookHandler(w, r, config, lockManager)
-	})
+	})))
+
+	// Webhooks with custom bodies, one path per payload profile
+	for i := range config.PayloadProfiles {
+		profile := &config.PayloadProfiles[i]
+		http.HandleFunc(profile.Path, allowSources(profile.Path, config, requireClientCert(config, func(w http.ResponseWriter, r *http.Request) {
+			profileWebhookHandler(w, r, profile, config, lockManager)
+		})))
+		logInfo("Registered payload profile", "profile", profile.Name, "path", profile.Path)
+	}
+
+	// Further change sources, one path per source
+	for _, source := range config.EventSources {
+		adapter := newSourceAdapter(source)
+		http.HandleFunc(source.Path, allowSources(source.Path, config, requireClientCert(config, func(w http.ResponseWriter, r *http.Request) {
+			sourceWebhookHandler(w, r, adapter, config, lockManager)
+		})))
+		logInfo("Registered event source", "source", source.Name, "type", source.Type, "path", source.Path)
+	}
 
 	// Health check endpoints
-	http.HandleFunc("/healthz", healthzHandler)
-	http.HandleFunc("/ready", readyHandler)
+	http.HandleFunc("/healthz", allowSources("/healthz", config, healthzHandler))
+	http.HandleFunc("/ready", allowSources("/ready", config, readyHandler))
+
+	server := &http.Server{
+		Addr:              config.ListenAddress,
+		ReadHeaderTimeout: time.Duration(config.ReadHeaderTimeout) * time.Second,
+		ReadTimeout:       time.Duration(config.ReadTimeout) * time.Second,
+		WriteTimeout:      time.Duration(config.WriteTimeout) * time.Second,
+		IdleTimeout:       time.Duration(config.IdleTimeout) * time.Second,
+		MaxHeaderBytes:    config.MaxHeaderBytes,
+	}
+
+	// Serve HTTPS if a certificate is configured
+	if config.TLSCertFile != "" {
+		tlsConfig, err := newTLSConfig(config)
+		if err != nil {
+			logError("TLS configuration error", "err", err)
+			os.Exit(1)
+		}
+		server.TLSConfig = tlsConfig
+
+		logInfo("Starting server", "address", config.ListenAddress, "tls", true, "client_auth", config.TLSClientCAFile != "")
+		if err := server.ListenAndServeTLS("", ""); err != nil {
+			logError("Server failed to start", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	logInfo("Starting server", "address", config.ListenAddress)
-	if err := http.ListenAndServe(config.ListenAddress, nil); err != nil {
+	if err := server.ListenAndServe(); err != nil {
 		logError("Server failed to start", "err", err)
 		os.Exit(1)
 	}
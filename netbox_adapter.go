@@ -0,0 +1,151 @@
+// netbox_adapter.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// netBoxAdapter decodes NetBox DNS webhooks into record and zone changes.
+// Payloads carrying several objects are JSON arrays. The adapter of /webhook
+// has no source name; NetBox event sources are named after their source.
+type netBoxAdapter struct {
+	source string
+}
+
+// Name implements SourceAdapter.
+func (a netBoxAdapter) Name() string {
+	if a.source == "" {
+		return EventSourceNetBox
+	}
+	return a.source
+}
+
+// Decode implements SourceAdapter. Webhooks for models other than records and
+// zones are ignored.
+func (a netBoxAdapter) Decode(body []byte) ([]*SourceChange, error) {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 || trimmed[0] != '[' {
+		return []*SourceChange{a.decodeObject(body)}, nil
+	}
+
+	var objects []json.RawMessage
+	if err := json.Unmarshal(trimmed, &objects); err != nil {
+		return nil, fmt.Errorf("invalid JSON payload: %v", err)
+	}
+	if len(objects) == 0 {
+		return nil, fmt.Errorf("empty payload")
+	}
+	changes := make([]*SourceChange, 0, len(objects))
+	for _, object := range objects {
+		changes = append(changes, a.decodeObject(object))
+	}
+	return changes, nil
+}
+
+// decodeObject decodes the webhook of a single object.
+func (a netBoxAdapter) decodeObject(body []byte) *SourceChange {
+	change := &SourceChange{Body: body}
+
+	var payload WebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		change.Err = fmt.Errorf("invalid JSON payload: %v", err)
+		return change
+	}
+	payload.Event = normalizeEvent(payload.Event)
+
+	// Dispatch on the model the webhook was sent for
+	model := payload.ModelName()
+	if payload.Format() == "" {
+		logDebug("Webhook payload names no model, assuming a record", "request_id", payload.RequestID)
+	}
+	change.Scope = model
+	if a.source != "" {
+		change.Scope = "source:" + a.source + "|" + model
+	}
+
+	switch model {
+	case ModelRecord:
+		change.Record, change.Err = payload.recordChange()
+		if change.Record != nil {
+			change.Record.Source = a.Name()
+		}
+	case ModelZone:
+		var zone ZonePayload
+		if err := json.Unmarshal(body, &zone); err != nil {
+			change.Err = fmt.Errorf("invalid JSON payload: %v", err)
+			return change
+		}
+		zone.Event = normalizeEvent(zone.Event)
+		if err := zone.Validate(); err != nil {
+			change.Err = err
+			return change
+		}
+		// Convert internationalized names before they are used in locks and updates
+		if err := zone.normalizeIDN(); err != nil {
+			change.Err = err
+			return change
+		}
+		change.Zone = &zone
+	default:
+		logInfo("Ignoring webhook for unsupported model",
+			"source", a.Name(),
+			"model", model,
+			"format", payload.Format(),
+			"event", payload.Event,
+			"request_id", payload.RequestID,
+		)
+		change.Ignored = "Webhook for unsupported model ignored"
+	}
+	return change
+}
+
+// recordChange validates the record payload, converts internationalized
+// names to their ASCII form and returns the record change it describes.
+// The snapshots only carry the zone ID; the record ID and zone name are taken
+// from the record data if the snapshots lack them.
+func (wp *WebhookPayload) recordChange() (*RecordChange, error) {
+	if err := wp.Validate(); err != nil {
+		return nil, err
+	}
+	if err := normalizeIDN(wp); err != nil {
+		return nil, err
+	}
+
+	change := &RecordChange{
+		Source:    EventSourceNetBox,
+		Event:     wp.Event,
+		RequestID: wp.RequestID,
+		Username:  wp.Username,
+		Timestamp: wp.Timestamp,
+	}
+
+	withZoneName := func(data *RecordData) *RecordData {
+		if data == nil {
+			return nil
+		}
+		if data.ID == 0 {
+			data.ID = wp.Data.ID
+		}
+		if data.Zone.ID == 0 || data.Zone.ID == wp.Data.Zone.ID {
+			data.Zone = wp.Data.Zone
+		}
+		return data
+	}
+
+	switch wp.Event {
+	case "created":
+		data := wp.Data
+		change.After = &data
+	case "deleted":
+		change.Before = withZoneName(snapshotToRecordData(wp.Snapshots.PreChange))
+	case "updated":
+		if wp.Snapshots.PreChange != nil {
+			change.Before = withZoneName(snapshotToRecordData(wp.Snapshots.PreChange))
+		}
+		change.After = withZoneName(snapshotToRecordData(wp.Snapshots.PostChange))
+	}
+	return change, nil
+}
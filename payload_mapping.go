@@ -0,0 +1,514 @@
+// payload_mapping.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// PayloadProfile maps the JSON sent by a custom NetBox webhook body template
+// to the webhook payload. Each field holds a mapping expression:
+//
+//   - a JSONPath starting with "$", e.g. "$.object.fqdn" or "$.items[0]['ttl']",
+//   - a Go template, e.g. "{{ .object.name }}.{{ .object.zone }}",
+//   - or a literal value, e.g. "netbox_dns.record".
+//
+// Empty fields are left unset.
+type PayloadProfile struct {
+	Name string `json:"name"`
+	Path string `json:"path"` // URL path the profile is served on, defaults to /webhook/<name>
+
+	Event     string `json:"event"`
+	RequestID string `json:"request_id"`
+	Username  string `json:"username"`
+	Timestamp string `json:"timestamp"`
+	Model     string `json:"model"`
+
+	Data       RecordMapping `json:"data"`
+	PreChange  RecordMapping `json:"prechange"`
+	PostChange RecordMapping `json:"postchange"`
+
+	fields []mappedField
+}
+
+// RecordMapping holds the mapping expressions of the fields of a record.
+// Zone is the zone name and ZoneID the zone ID; snapshots only carry the zone ID.
+type RecordMapping struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	FQDN       string `json:"fqdn"`
+	Type       string `json:"type"`
+	Value      string `json:"value"`
+	TTL        string `json:"ttl"`
+	DisablePTR string `json:"disable_ptr"`
+	Zone       string `json:"zone"`
+	ZoneID     string `json:"zone_id"`
+}
+
+// Kinds of mapped values.
+const (
+	mappedString = iota
+	mappedInt
+	mappedBool
+)
+
+// mappedField is a compiled mapping expression and the payload field it sets.
+type mappedField struct {
+	target []string // e.g. ["snapshots", "postchange", "ttl"]
+	kind   int
+	expr   *mappingExpr
+}
+
+// mappingExpr is a compiled mapping expression.
+type mappingExpr struct {
+	raw     string
+	path    []pathStep
+	tmpl    *template.Template
+	keys    [][]string // Key paths a template refers to
+	literal bool
+}
+
+// pathStep is a member name or array index of a JSONPath.
+type pathStep struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// mappingFuncs are the functions available in mapping templates.
+var mappingFuncs = template.FuncMap{
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+}
+
+// parse compiles the mapping expressions of the profile.
+func (p *PayloadProfile) parse() error {
+	if p.Name == "" {
+		return fmt.Errorf("payload profile without name")
+	}
+	if p.Path == "" {
+		p.Path = "/webhook/" + p.Name
+	}
+	if !strings.HasPrefix(p.Path, "/") {
+		return fmt.Errorf("payload profile %s: path %q must start with /", p.Name, p.Path)
+	}
+	switch p.Path {
+	case "/webhook", "/healthz", "/ready":
+		return fmt.Errorf("payload profile %s: path %s is reserved", p.Name, p.Path)
+	}
+
+	// Snapshots only carry the zone ID
+	if p.PreChange.Zone != "" {
+		return fmt.Errorf("payload profile %s: prechange.zone is not supported, map prechange.zone_id instead", p.Name)
+	}
+	if p.PostChange.Zone != "" {
+		return fmt.Errorf("payload profile %s: postchange.zone is not supported, map postchange.zone_id instead", p.Name)
+	}
+
+	p.fields = nil
+	add := func(expr string, kind int, target ...string) error {
+		if expr == "" {
+			return nil
+		}
+		compiled, err := compileMappingExpr(expr)
+		if err != nil {
+			return fmt.Errorf("payload profile %s: %s: %v", p.Name, strings.Join(target, "."), err)
+		}
+		p.fields = append(p.fields, mappedField{target: target, kind: kind, expr: compiled})
+		return nil
+	}
+	addRecord := func(m RecordMapping, prefix ...string) error {
+		for _, f := range []struct {
+			expr   string
+			kind   int
+			target []string
+		}{
+			{m.ID, mappedInt, []string{"id"}},
+			{m.Name, mappedString, []string{"name"}},
+			{m.FQDN, mappedString, []string{"fqdn"}},
+			{m.Type, mappedString, []string{"type"}},
+			{m.Value, mappedString, []string{"value"}},
+			{m.TTL, mappedInt, []string{"ttl"}},
+			{m.DisablePTR, mappedBool, []string{"disable_ptr"}},
+		} {
+			if err := add(f.expr, f.kind, append(append([]string{}, prefix...), f.target...)...); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, f := range []struct {
+		expr   string
+		target string
+	}{
+		{p.Event, "event"},
+		{p.RequestID, "request_id"},
+		{p.Username, "username"},
+		{p.Timestamp, "timestamp"},
+		{p.Model, "model"},
+	} {
+		if err := add(f.expr, mappedString, f.target); err != nil {
+			return err
+		}
+	}
+	if err := addRecord(p.Data, "data"); err != nil {
+		return err
+	}
+	if err := add(p.Data.Zone, mappedString, "data", "zone", "name"); err != nil {
+		return err
+	}
+	if err := add(p.Data.ZoneID, mappedInt, "data", "zone", "id"); err != nil {
+		return err
+	}
+	if err := addRecord(p.PreChange, "snapshots", "prechange"); err != nil {
+		return err
+	}
+	if err := add(p.PreChange.ZoneID, mappedInt, "snapshots", "prechange", "zone"); err != nil {
+		return err
+	}
+	if err := addRecord(p.PostChange, "snapshots", "postchange"); err != nil {
+		return err
+	}
+	return add(p.PostChange.ZoneID, mappedInt, "snapshots", "postchange", "zone")
+}
+
+// Map converts a custom webhook body to the JSON of a webhook payload.
+// Bodies that are JSON arrays are mapped element by element.
+func (p *PayloadProfile) Map(body []byte) ([]byte, error) {
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid JSON payload: %v", err)
+	}
+
+	if objects, ok := doc.([]interface{}); ok {
+		mapped := make([]map[string]interface{}, 0, len(objects))
+		for i, object := range objects {
+			payload, err := p.mapObject(object)
+			if err != nil {
+				return nil, fmt.Errorf("object %d: %v", i, err)
+			}
+			mapped = append(mapped, payload)
+		}
+		return json.Marshal(mapped)
+	}
+
+	payload, err := p.mapObject(doc)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(payload)
+}
+
+// mapObject evaluates the mapping expressions against one object.
+func (p *PayloadProfile) mapObject(doc interface{}) (map[string]interface{}, error) {
+	payload := make(map[string]interface{})
+	for _, field := range p.fields {
+		value, ok, err := field.expr.eval(doc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", strings.Join(field.target, "."), err)
+		}
+		if !ok {
+			continue
+		}
+		converted, err := convertMappedValue(value, field.kind)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", strings.Join(field.target, "."), err)
+		}
+		if converted == nil {
+			continue
+		}
+		setPath(payload, field.target, converted)
+	}
+	return payload, nil
+}
+
+// compileMappingExpr compiles a JSONPath, Go template or literal mapping expression.
+func compileMappingExpr(raw string) (*mappingExpr, error) {
+	switch {
+	case strings.HasPrefix(raw, "$"):
+		path, err := parseJSONPath(raw)
+		if err != nil {
+			return nil, err
+		}
+		return &mappingExpr{raw: raw, path: path}, nil
+	case strings.Contains(raw, "{{"):
+		tmpl, err := template.New("mapping").Funcs(mappingFuncs).Option("missingkey=error").Parse(raw)
+		if err != nil {
+			return nil, err
+		}
+		return &mappingExpr{raw: raw, tmpl: tmpl, keys: templateKeys(tmpl.Tree.Root, []string{})}, nil
+	}
+	return &mappingExpr{raw: raw, literal: true}, nil
+}
+
+// eval evaluates the expression against doc. It reports false if a JSONPath
+// does not match or a template refers to a missing key.
+func (e *mappingExpr) eval(doc interface{}) (interface{}, bool, error) {
+	switch {
+	case e.literal:
+		return e.raw, true, nil
+	case e.tmpl != nil:
+		for _, key := range e.keys {
+			if !hasKeyPath(doc, key) {
+				return nil, false, nil
+			}
+		}
+		var out strings.Builder
+		if err := e.tmpl.Execute(&out, templateData(doc)); err != nil {
+			return nil, false, err
+		}
+		return out.String(), true, nil
+	}
+
+	current := doc
+	for _, step := range e.path {
+		if step.isIndex {
+			items, ok := current.([]interface{})
+			if !ok || step.index < 0 || step.index >= len(items) {
+				return nil, false, nil
+			}
+			current = items[step.index]
+			continue
+		}
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false, nil
+		}
+		if current, ok = object[step.key]; !ok {
+			return nil, false, nil
+		}
+	}
+	return current, true, nil
+}
+
+// templateData returns a copy of doc with JSON null values replaced by empty
+// strings, which templates would otherwise render as "<no value>".
+func templateData(doc interface{}) interface{} {
+	switch v := doc.(type) {
+	case nil:
+		return ""
+	case map[string]interface{}:
+		data := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			data[key] = templateData(value)
+		}
+		return data
+	case []interface{}:
+		data := make([]interface{}, len(v))
+		for i, value := range v {
+			data[i] = templateData(value)
+		}
+		return data
+	}
+	return doc
+}
+
+// templateKeys returns the key paths the template node refers to, relative to
+// the root of the data. dot is the key path of dot, or nil where dot is not a
+// key path of the data, e.g. inside range. Keys under such a dot are not
+// returned; a missing one fails the execution of the template instead.
+func templateKeys(node parse.Node, dot []string) [][]string {
+	var keys [][]string
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			keys = append(keys, templateKeys(child, dot)...)
+		}
+	case *parse.ActionNode:
+		keys = templateKeys(n.Pipe, dot)
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+		for _, cmd := range n.Cmds {
+			keys = append(keys, templateKeys(cmd, dot)...)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			keys = append(keys, templateKeys(arg, dot)...)
+		}
+	case *parse.FieldNode:
+		if dot != nil {
+			keys = append(keys, joinKeys(dot, n.Ident))
+		}
+	case *parse.VariableNode:
+		// $ is the root of the data
+		if n.Ident[0] == "$" && len(n.Ident) > 1 {
+			keys = append(keys, joinKeys(nil, n.Ident[1:]))
+		}
+	case *parse.ChainNode:
+		keys = templateKeys(n.Node, dot)
+	case *parse.IfNode:
+		keys = append(templateKeys(n.Pipe, dot), templateKeys(n.List, dot)...)
+		keys = append(keys, templateKeys(n.ElseList, dot)...)
+	case *parse.WithNode:
+		// Dot is the value of the pipeline inside with
+		keys = append(templateKeys(n.Pipe, dot), templateKeys(n.List, pipeKeyPath(n.Pipe, dot))...)
+		keys = append(keys, templateKeys(n.ElseList, dot)...)
+	case *parse.RangeNode:
+		keys = append(templateKeys(n.Pipe, dot), templateKeys(n.List, nil)...)
+		keys = append(keys, templateKeys(n.ElseList, dot)...)
+	}
+	return keys
+}
+
+// pipeKeyPath returns the key path of a pipeline that is a single field, or
+// nil if it is anything else.
+func pipeKeyPath(pipe *parse.PipeNode, dot []string) []string {
+	if dot == nil || pipe == nil || len(pipe.Decl) > 0 || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return nil
+	}
+	switch n := pipe.Cmds[0].Args[0].(type) {
+	case *parse.FieldNode:
+		return joinKeys(dot, n.Ident)
+	case *parse.DotNode:
+		return dot
+	}
+	return nil
+}
+
+// joinKeys returns a new key path of keys appended to prefix.
+func joinKeys(prefix, keys []string) []string {
+	return append(append([]string{}, prefix...), keys...)
+}
+
+// hasKeyPath reports whether the key path exists in doc. A value on the path
+// that is not an object ends the check; the template fails on it instead.
+func hasKeyPath(doc interface{}, keys []string) bool {
+	for _, key := range keys {
+		object, ok := doc.(map[string]interface{})
+		if !ok {
+			return true
+		}
+		if doc, ok = object[key]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// parseJSONPath parses the supported JSONPath subset: the root "$" followed by
+// ".member", "['member']" and "[index]" steps.
+func parseJSONPath(raw string) ([]pathStep, error) {
+	var steps []pathStep
+	rest := strings.TrimPrefix(raw, "$")
+	for rest != "" {
+		switch {
+		case rest[0] == '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("invalid JSONPath %q: empty member name", raw)
+			}
+			steps = append(steps, pathStep{key: rest[:end]})
+			rest = rest[end:]
+		case strings.HasPrefix(rest, "['"):
+			end := strings.Index(rest, "']")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid JSONPath %q: unterminated member name", raw)
+			}
+			steps = append(steps, pathStep{key: rest[2:end]})
+			rest = rest[end+2:]
+		case rest[0] == '[':
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid JSONPath %q: unterminated index", raw)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("invalid JSONPath %q: unsupported index %q", raw, rest[1:end])
+			}
+			steps = append(steps, pathStep{index: index, isIndex: true})
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("invalid JSONPath %q", raw)
+		}
+	}
+	return steps, nil
+}
+
+// convertMappedValue converts a mapped value to the kind of its payload field.
+// Empty values and JSON null yield nil, leaving the field unset.
+func convertMappedValue(value interface{}, kind int) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	if s, ok := value.(string); ok {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return nil, nil
+		}
+		value = s
+	}
+
+	switch kind {
+	case mappedInt:
+		switch v := value.(type) {
+		case json.Number:
+			n, err := strconv.Atoi(v.String())
+			if err != nil {
+				return nil, fmt.Errorf("%q is not an integer", v)
+			}
+			return n, nil
+		case string:
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not an integer", v)
+			}
+			return n, nil
+		}
+		return nil, fmt.Errorf("%v is not an integer", value)
+	case mappedBool:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not a boolean", v)
+			}
+			return b, nil
+		}
+		return nil, fmt.Errorf("%v is not a boolean", value)
+	}
+
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	return nil, fmt.Errorf("%v is not a scalar value", value)
+}
+
+// setPath sets a value in nested maps, creating the intermediate maps.
+func setPath(m map[string]interface{}, path []string, value interface{}) {
+	for _, key := range path[:len(path)-1] {
+		next, ok := m[key].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			m[key] = next
+		}
+		m = next
+	}
+	m[path[len(path)-1]] = value
+}
@@ -0,0 +1,59 @@
+// problem.go
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Problem is an RFC 9457 problem details object describing an error response.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+
+	// Errors lists the field errors of an invalid payload.
+	Errors ValidationErrors `json:"errors,omitempty"`
+	// Conflicts lists the RRsets conflicting with a CNAME record.
+	Conflicts []string `json:"conflicts,omitempty"`
+}
+
+// writeProblem responds with an RFC 9457 problem details object for status.
+func writeProblem(w http.ResponseWriter, status int, detail string) {
+	writeProblemDetails(w, Problem{Status: status, Detail: detail})
+}
+
+// writeProblemDetails responds with the problem details object p.
+// The title defaults to the status text and the type to "about:blank".
+func writeProblemDetails(w http.ResponseWriter, p Problem) {
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// writeConflict responds with 409 and the RRsets conflicting with a CNAME
+// record, or with 503 if the conflict check could not be completed.
+func writeConflict(w http.ResponseWriter, err error) {
+	var conflict *CNAMEConflictError
+	if !errors.As(err, &conflict) {
+		writeProblem(w, http.StatusServiceUnavailable, "CNAME conflict check failed")
+		return
+	}
+	writeProblemDetails(w, Problem{
+		Status:    http.StatusConflict,
+		Title:     "CNAME conflict",
+		Detail:    err.Error(),
+		Conflicts: conflict.Conflicts,
+	})
+}
@@ -2,8 +2,24 @@
 
 package main
 
+import (
+	"net/netip"
+	"strings"
+)
+
 // handlePTRUpdate manages PTR records based on the event.
-func handlePTRUpdate(event string, preData *RecordData, postData *RecordData, config *Config, lockManager *RecordLockManager) {
+// preData and postData are nil if the respective snapshot has no PTR record.
+// The delivery of the change is released if the PTR update fails.
+func handlePTRUpdate(event, transition string, change *RecordChange, preData *RecordData, postData *RecordData, config *Config, lockManager *RecordLockManager) {
+	// PTR records are managed by NetBox DNS itself
+	if config.PTRSource == PTRSourceNetBox {
+		logDebug("Skipping auto-generated PTR, NetBox DNS is the PTR source",
+			"event", event,
+			"fqdn", getFQDN(preData, postData),
+		)
+		return
+	}
+
 	go func() {
 		var oldIP, newIP string
 
@@ -29,132 +45,329 @@ func handlePTRUpdate(event string, preData *RecordData, postData *RecordData, co
 			return
 		}
 
-		// Determine the PTR record names
-		var oldPTRName, newPTRName string
-
+		// Apply the address policy to the new address; skipped addresses are
+		// reported and get no PTR record. The PTR record of the old address is
+		// always released, even if the address is excluded by now.
 		if oldIPValid {
-			oldPTRName = reverseDNSName(oldIP)
+			oldIP = ptrReleaseAddress(oldIP, config)
 		}
-
 		if newIPValid {
-			newPTRName = reverseDNSName(newIP)
+			newIP = applyPTRAddressPolicy(event, newIP, getFQDN(nil, postData), config)
+			newIPValid = newIP != ""
+		}
+		if !oldIPValid && !newIPValid {
+			return
 		}
 
-		// Lock on the PTR names to prevent race conditions
-		if oldPTRName != "" {
-			lockManager.AcquireLock(oldPTRName)
-			defer lockManager.ReleaseLock(oldPTRName)
-		}
-
-		if newPTRName != "" && newPTRName != oldPTRName {
-			lockManager.AcquireLock(newPTRName)
-			defer lockManager.ReleaseLock(newPTRName)
-		}
-
-		// Construct nsupdate script based on the event
-		var script string
-
-		switch event {
-		case "created":
-			// Add PTR record
-			if postData != nil {
-				script = ConstructPTRUpdateScript(
-					extractHost(config.BindServerAddress),
-					extractPort(config.BindServerAddress),
-					newPTRName,
-					postData.FQDN,
-					"created",
-					300, // Default TTL or use postData.TTL if available
-				)
-			} else {
-				logWarn("postData is nil in handlePTRUpdate for created event",
-					"event", event,
-					"new_ip", newIP,
-					"new_ptr", newPTRName,
-				)
-				return
-			}
-		case "deleted":
-			// Delete PTR record
+		// Determine the PTR record names. The record may still claim another
+		// owner name than the one derived from its old address, e.g. after the
+		// reverse zones changed; both are locked and the owner name to release
+		// is resolved once the locks are held.
+		var oldPTRName, claimedPTRName, newPTRName string
+
+		if oldIPValid {
+			oldPTRName = ptrOwnerName(oldIP, config)
 			if preData != nil {
-				script = ConstructPTRUpdateScript(
-					extractHost(config.BindServerAddress),
-					extractPort(config.BindServerAddress),
-					oldPTRName,
-					preData.FQDN,
-					"deleted",
-					0,
-				)
-			} else {
-				logWarn("preData is nil in handlePTRUpdate for deleted event",
-					"event", event,
-					"old_ip", oldIP,
-					"old_ptr", oldPTRName,
-				)
-				return
+				if owner, exists := ptrIndex.Owner(preData.ID); exists && !sameName(owner, oldPTRName) {
+					claimedPTRName = owner
+				}
 			}
-		case "updated":
-			// Delete old PTR and add new PTR
-			if oldPTRName != "" && preData != nil {
-				deleteScript := ConstructPTRUpdateScript(
-					extractHost(config.BindServerAddress),
-					extractPort(config.BindServerAddress),
-					oldPTRName,
-					preData.FQDN,
-					"deleted",
-					0,
-				)
-				script += deleteScript
+		}
+
+		if newIPValid {
+			newPTRName = ptrOwnerName(newIP, config)
+		}
+
+		// Skip PTR updates for reverse zones the DNS server is not authoritative for
+		var oldZone, newZone string
+		if newPTRName != "" {
+			var ok bool
+			if newZone, ok = reverseZoneFor(newIP, newPTRName, config); !ok {
+				logDebug("Skipping PTR update outside known reverse zones", "event", event, "ip", newIP, "ptr", newPTRName)
+				newPTRName = ""
 			}
-			if newPTRName != "" && postData != nil {
-				createScript := ConstructPTRUpdateScript(
-					extractHost(config.BindServerAddress),
-					extractPort(config.BindServerAddress),
-					newPTRName,
-					postData.FQDN,
-					"created",
-					300, // Default TTL or use postData.TTL if available
-				)
-				script += createScript
+		}
+		if oldPTRName != "" {
+			var ok bool
+			if oldZone, ok = reverseZoneFor(oldIP, oldPTRName, config); !ok {
+				logDebug("Skipping PTR update outside known reverse zones", "event", event, "ip", oldIP, "ptr", oldPTRName)
+				oldPTRName = ""
 			}
 		}
+		if oldPTRName == "" && claimedPTRName == "" && newPTRName == "" {
+			return
+		}
+
+		// The PTR changes are merged into batch messages only if they touch a single reverse zone
+		zone := newZone
+		if newPTRName == "" {
+			zone = oldZone
+		} else if oldPTRName != "" && !sameName(oldZone, newZone) {
+			zone = ""
+		}
+		if claimedPTRName != "" {
+			zone = ""
+		}
+
+		// Queue the PTR update holding the locks on the PTR names. The claims
+		// are staged when the script is built and settled once it was executed.
+		var undo *ptrUndo
+		updateBatcher.Submit(change.RequestID, &updateJob{
+			Locks: []string{oldPTRName, claimedPTRName, newPTRName},
+			Zone:  zone,
+			Script: func() string {
+				var script string
+				oldPTRName = ptrReleaseOwner(preData, claimedPTRName, oldPTRName)
+				script, undo = buildPTRScript(event, transition, oldIP, newIP, oldPTRName, newPTRName, preData, postData, config)
+				return script
+			},
+			Done: func(err error, logProcessed logFunc) {
+				settlePTRClaims(err, undo, config)
+				if err != nil {
+					releaseDelivery(change.deliveryKey)
+				}
+				logPTRResult(err, logProcessed, event, transition, oldIP, newIP, oldPTRName, newPTRName, preData, postData)
+			},
+		}, config, lockManager)
+	}()
+}
 
-		// If script is empty, no valid PTR updates are needed
-		if script == "" {
-			logWarn("No valid PTR updates needed",
+// buildPTRScript stages the PTR claims of a forward record and returns the
+// nsupdate script for the resulting PTR changes and the undo of the claims.
+// Claims that need no DNS change are persisted right away. It must be called
+// with the locks on the PTR names held.
+func buildPTRScript(event, transition, oldIP, newIP, oldPTRName, newPTRName string, preData, postData *RecordData, config *Config) (string, *ptrUndo) {
+	// Update the claims of the forward record; a PTR target is only removed
+	// once no forward record claims it anymore
+	var release *ptrRelease
+	var claim *ptrClaimRequest
+	if oldPTRName != "" && preData != nil {
+		release = &ptrRelease{Owner: oldPTRName, RecordID: preData.ID, FQDN: preData.FQDN}
+	}
+	if newPTRName != "" && postData != nil {
+		claim = &ptrClaimRequest{
+			Owner: newPTRName,
+			Claim: PTRClaim{
+				RecordID: postData.ID,
+				FQDN:     postData.FQDN,
+				TTL:      postData.TTL,
+				Primary:  isPTRPrimary(postData, config),
+			},
+		}
+	}
+	changes, undo := ptrIndex.Apply(release, claim, config.PTRPrimaryPolicy)
+
+	// Construct one update per owner name
+	var script string
+	for _, change := range changes {
+		// Explicit PTR records managed in NetBox reverse zones take precedence
+		if target, exists := explicitPTRs.Explicit(change.Owner); exists {
+			logInfo("Skipping auto-generated PTR, explicit PTR record takes precedence",
 				"event", event,
-				"old_ip", oldIP,
-				"new_ip", newIP,
+				"transition", transition,
+				"ptr", change.Owner,
+				"explicit_target", target,
 			)
-			return
+			continue
 		}
+		script += ConstructPTRChangeScript(
+			extractHost(config.BindServerAddress),
+			extractPort(config.BindServerAddress),
+			change.Owner,
+			change.Remove,
+			change.Add,
+			ptrTTL(change.Owner, change.TTL, config),
+		)
+	}
 
-		logDebug("Outgoing nsupdate script for PTR event",
+	// If script is empty, the PTR targets are unchanged
+	if script == "" {
+		logDebug("No PTR changes needed",
 			"event", event,
-			"script", script,
+			"transition", transition,
+			"old_ip", oldIP,
+			"new_ip", newIP,
+			"old_ptr_claimants", ptrIndex.Claimants(oldPTRName),
+			"new_ptr_claimants", ptrIndex.Claimants(newPTRName),
 		)
+		settlePTRClaims(nil, undo, config)
+		return "", nil
+	}
 
-		// Execute nsupdate
-		err := ExecuteNSUpdate(script, config)
-		if err != nil {
-			logError("Failed to execute nsupdate for PTR record",
-				"event", event,
-				"err", err,
-				"old_ip", oldIP,
-				"new_ip", newIP,
-				"old_ptr", oldPTRName,
-				"new_ptr", newPTRName,
-			)
-			return
-		}
+	logDebug("Outgoing nsupdate script for PTR event",
+		"event", event,
+		"script", script,
+	)
 
-		logInfo("Processed PTR record",
+	return script, undo
+}
+
+// settlePTRClaims persists the PTR claims staged for an update if the update
+// was applied, and reverts them if it failed.
+func settlePTRClaims(err error, undo *ptrUndo, config *Config) {
+	if err != nil {
+		err = ptrIndex.Rollback(undo)
+	} else {
+		err = ptrIndex.Commit()
+	}
+	if err != nil {
+		logError("Failed to persist PTR index", "err", err, "file", config.PTRIndexFile)
+	}
+}
+
+// logPTRResult logs the result of a PTR update.
+func logPTRResult(err error, logProcessed logFunc, event, transition, oldIP, newIP, oldPTRName, newPTRName string, preData, postData *RecordData) {
+	if err != nil {
+		logError("Failed to execute nsupdate for PTR record",
 			"event", event,
-			"fqdn", getFQDN(preData, postData),
+			"transition", transition,
+			"err", err,
 			"old_ip", oldIP,
 			"new_ip", newIP,
 			"old_ptr", oldPTRName,
 			"new_ptr", newPTRName,
 		)
-	}()
+		return
+	}
+
+	if transition == ptrTransitionRenamed {
+		logProcessed("Renamed PTR record target",
+			"event", event,
+			"transition", transition,
+			"ip", newIP,
+			"ptr", newPTRName,
+			"old_fqdn", preData.FQDN,
+			"new_fqdn", postData.FQDN,
+		)
+		return
+	}
+
+	logProcessed("Processed PTR record",
+		"event", event,
+		"transition", transition,
+		"fqdn", getFQDN(preData, postData),
+		"old_ip", oldIP,
+		"new_ip", newIP,
+		"old_ptr", oldPTRName,
+		"new_ptr", newPTRName,
+	)
+}
+
+// applyPTRAddressPolicy returns the address to create the PTR record for,
+// or an empty string if the address never gets a PTR record.
+func applyPTRAddressPolicy(event, ip, fqdn string, config *Config) string {
+	addr, reason := ptrAddress(ip, config)
+	if reason != "" {
+		logInfo("Skipping PTR for address excluded by policy",
+			"event", event,
+			"fqdn", fqdn,
+			"ip", ip,
+			"reason", reason,
+		)
+	}
+	return addr
+}
+
+// ptrReleaseAddress returns the address the PTR record of a forward record
+// with address ip was created for. Unlike applyPTRAddressPolicy it excludes no
+// address, so that PTR records created before an exclusion was configured are
+// still removed.
+func ptrReleaseAddress(ip string, config *Config) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	if addr.Is4In6() && config.IPv4MappedPTR == IPv4MappedIPv4 {
+		addr = addr.Unmap()
+	}
+	return addr.String()
+}
+
+// ptrReleaseOwner returns the PTR owner name to release for a forward record:
+// the first of the candidate owner names the record claims in the PTR index,
+// or the last non-empty candidate if it claims none of them. It must be called
+// with the locks on the candidates held, so that no pending update changes the
+// claims of the record in between.
+func ptrReleaseOwner(data *RecordData, candidates ...string) string {
+	owner := ""
+	for _, candidate := range candidates {
+		if candidate == "" {
+			continue
+		}
+		if data != nil && ptrIndex.Claims(data.ID, candidate) {
+			return candidate
+		}
+		owner = candidate
+	}
+	return owner
+}
+
+// PTR transitions between the pre- and post-change snapshots of a forward record.
+const (
+	ptrTransitionNone           = "none"            // Neither snapshot has a PTR record
+	ptrTransitionUnchanged      = "unchanged"       // Same address, name and TTL
+	ptrTransitionEnabled        = "enabled"         // PTR turned on, or the record became an A/AAAA record
+	ptrTransitionDisabled       = "disabled"        // PTR turned off, or the record is no longer an A/AAAA record
+	ptrTransitionAddressChanged = "address_changed" // Same name, different address
+	ptrTransitionRenamed        = "renamed"         // Same address, different name
+	ptrTransitionMoved          = "moved"           // Different address and different name
+	ptrTransitionTTLChanged     = "ttl_changed"     // Same address and name, different TTL
+)
+
+// classifyPTRTransition determines how the PTR record of a forward record
+// changes between its pre- and post-change snapshots.
+func classifyPTRTransition(preData, postData *RecordData) string {
+	preHasPTR := hasPTR(preData)
+	postHasPTR := hasPTR(postData)
+
+	switch {
+	case !preHasPTR && !postHasPTR:
+		return ptrTransitionNone
+	case !preHasPTR:
+		return ptrTransitionEnabled
+	case !postHasPTR:
+		return ptrTransitionDisabled
+	}
+
+	preAddr, _ := netip.ParseAddr(preData.Value)
+	postAddr, _ := netip.ParseAddr(postData.Value)
+	addressChanged := preAddr != postAddr
+	renamed := !sameName(preData.FQDN, postData.FQDN)
+
+	switch {
+	case addressChanged && renamed:
+		return ptrTransitionMoved
+	case addressChanged:
+		return ptrTransitionAddressChanged
+	case renamed:
+		return ptrTransitionRenamed
+	case ttlValue(preData.TTL) != ttlValue(postData.TTL):
+		return ptrTransitionTTLChanged
+	}
+	return ptrTransitionUnchanged
+}
+
+// hasPTR reports whether a forward record snapshot should have a PTR record.
+func hasPTR(data *RecordData) bool {
+	if data == nil || data.DisablePTR || !isValidIP(data.Value) {
+		return false
+	}
+	recordType := strings.ToUpper(data.Type)
+	return recordType == "A" || recordType == "AAAA"
+}
+
+// ptrSide returns data if the snapshot has a PTR record, nil otherwise.
+func ptrSide(data *RecordData) *RecordData {
+	if !hasPTR(data) {
+		return nil
+	}
+	return data
+}
+
+// ttlValue returns the TTL of a record, 0 if it is not set.
+func ttlValue(ttl *int) int {
+	if ttl == nil {
+		return 0
+	}
+	return *ttl
 }
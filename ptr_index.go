@@ -0,0 +1,379 @@
+// ptr_index.go
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PTR primary policies select the PTR targets of an address claimed by several forward records.
+const (
+	// PTRPrimaryOldest points the PTR record at the oldest claimant.
+	PTRPrimaryOldest = "oldest"
+	// PTRPrimaryExplicit points the PTR record at the claimant flagged as primary, falling back to the oldest.
+	PTRPrimaryExplicit = "explicit"
+	// PTRPrimaryAll creates one PTR record per claimant.
+	PTRPrimaryAll = "all"
+)
+
+// PTRClaim records a forward record claiming an address for its PTR record.
+type PTRClaim struct {
+	RecordID  int       `json:"record_id"`
+	FQDN      string    `json:"fqdn"`
+	TTL       *int      `json:"ttl,omitempty"`
+	Primary   bool      `json:"primary,omitempty"`
+	ClaimedAt time.Time `json:"claimed_at"`
+}
+
+// ptrRelease identifies the claim of a forward record to remove from an owner name.
+// FQDN is used as the PTR target to delete if the claim is not indexed.
+type ptrRelease struct {
+	Owner    string
+	RecordID int
+	FQDN     string
+}
+
+// ptrChange describes the PTR targets to remove and add at one owner name.
+type ptrChange struct {
+	Owner  string
+	Remove []string
+	Add    []string
+	TTL    *int // TTL of the forward record the remaining targets belong to
+}
+
+// PTRIndex is a persistent index of the forward records claiming each PTR owner name.
+type PTRIndex struct {
+	mu     sync.Mutex
+	path   string
+	claims map[string][]PTRClaim
+}
+
+// ptrIndex holds the PTR claims of all forward records seen by this service.
+var ptrIndex = &PTRIndex{}
+
+// initPTRIndex loads the PTR index from the configured file, if it exists.
+func initPTRIndex(config *Config) error {
+	ptrIndex.mu.Lock()
+	defer ptrIndex.mu.Unlock()
+
+	ptrIndex.path = config.PTRIndexFile
+	ptrIndex.claims = make(map[string][]PTRClaim)
+	if ptrIndex.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(ptrIndex.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read PTR index: %v", err)
+	}
+	if err := json.Unmarshal(data, &ptrIndex.claims); err != nil {
+		return fmt.Errorf("failed to parse PTR index %s: %v", ptrIndex.path, err)
+	}
+	return nil
+}
+
+// ptrUndo holds what is needed to revert the claims changed by Apply.
+type ptrUndo struct {
+	releaseOwner  string
+	released      *PTRClaim // Claim removed from releaseOwner, nil if none was indexed
+	claimOwner    string
+	claimRecordID int
+	replaced      *PTRClaim // Previous claim of the record on claimOwner, nil if none
+}
+
+// Apply releases a claim and adds a claim in one step and returns the resulting
+// PTR changes per owner name. Either argument may be nil. A claim by a record
+// that already claims the owner name replaces it but keeps its age.
+//
+// The change is staged in memory only: once the PTR changes were applied to
+// the DNS server it is persisted with Commit, otherwise it is reverted with
+// Rollback and the returned undo, so that a retry computes the same changes.
+func (i *PTRIndex) Apply(release *ptrRelease, claim *ptrClaimRequest, policy string) ([]ptrChange, *ptrUndo) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.claims == nil {
+		i.claims = make(map[string][]PTRClaim)
+	}
+
+	// Remember the targets of all affected owner names before the change
+	owners := []string{}
+	before := make(map[string][]string)
+	if release != nil {
+		release.Owner = canonicalName(release.Owner)
+		owners = append(owners, release.Owner)
+	}
+	if claim != nil {
+		claim.Owner = canonicalName(claim.Owner)
+		if release == nil || claim.Owner != release.Owner {
+			owners = append(owners, claim.Owner)
+		}
+	}
+	beforeTTL := make(map[string]int)
+	for _, owner := range owners {
+		before[owner] = ptrTargets(i.claims[owner], policy)
+		beforeTTL[owner] = ttlValue(primaryClaimTTL(i.claims[owner], policy))
+	}
+
+	undo := &ptrUndo{}
+	if claim != nil {
+		undo.claimOwner = claim.Owner
+		undo.claimRecordID = claim.Claim.RecordID
+		undo.replaced = findClaim(i.claims[claim.Owner], claim.Claim.RecordID)
+	}
+
+	if release != nil {
+		undo.releaseOwner = release.Owner
+		undo.released = findClaim(i.claims[release.Owner], release.RecordID)
+		claims, found := removeClaim(i.claims[release.Owner], release.RecordID)
+		if !found && release.FQDN != "" {
+			// Records claimed before the index existed still own their PTR target
+			before[release.Owner] = appendUnique(before[release.Owner], canonicalName(release.FQDN))
+		}
+		i.claims[release.Owner] = claims
+	}
+	if claim != nil {
+		if undo.replaced != nil {
+			claim.Claim.ClaimedAt = undo.replaced.ClaimedAt
+		}
+		if claim.Claim.ClaimedAt.IsZero() {
+			claim.Claim.ClaimedAt = time.Now().UTC()
+		}
+		claims, _ := removeClaim(i.claims[claim.Owner], claim.Claim.RecordID)
+		i.claims[claim.Owner] = append(claims, claim.Claim)
+	}
+
+	// Compute the difference per owner name
+	var changes []ptrChange
+	for _, owner := range owners {
+		after := ptrTargets(i.claims[owner], policy)
+		if len(i.claims[owner]) == 0 {
+			delete(i.claims, owner)
+		}
+		change := ptrChange{
+			Owner:  owner,
+			Remove: subtractTargets(before[owner], after),
+			Add:    subtractTargets(after, before[owner]),
+			TTL:    primaryClaimTTL(i.claims[owner], policy),
+		}
+		if len(change.Remove) == 0 && len(change.Add) == 0 && len(after) > 0 && ttlValue(change.TTL) != beforeTTL[owner] {
+			// Re-adding the unchanged targets updates the TTL of the RRset
+			change.Add = after
+		}
+		if len(change.Remove) > 0 || len(change.Add) > 0 {
+			changes = append(changes, change)
+		}
+	}
+
+	return changes, undo
+}
+
+// Commit persists the claims staged by Apply.
+func (i *PTRIndex) Commit() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.save()
+}
+
+// Rollback reverts the claims changed by Apply, e.g. because the PTR changes
+// could not be applied to the DNS server. Claims of other records changed in
+// the meantime are kept.
+func (i *PTRIndex) Rollback(undo *ptrUndo) error {
+	if undo == nil {
+		return nil
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	restore := func(owner string, recordID int, previous *PTRClaim) {
+		claims, _ := removeClaim(i.claims[owner], recordID)
+		if previous != nil {
+			claims = append(claims, *previous)
+		}
+		if len(claims) == 0 {
+			delete(i.claims, owner)
+			return
+		}
+		i.claims[owner] = claims
+	}
+	if undo.claimOwner != "" {
+		restore(undo.claimOwner, undo.claimRecordID, undo.replaced)
+	}
+	if undo.released != nil {
+		restore(undo.releaseOwner, undo.released.RecordID, undo.released)
+	}
+	return i.save()
+}
+
+// Targets returns the PTR targets of the owner name and the TTL they follow.
+func (i *PTRIndex) Targets(owner, policy string) ([]string, *int) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	claims := i.claims[canonicalName(owner)]
+	return ptrTargets(claims, policy), primaryClaimTTL(claims, policy)
+}
+
+// Owner returns the owner name claimed by the forward record, if any.
+func (i *PTRIndex) Owner(recordID int) (string, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for owner, claims := range i.claims {
+		if findClaim(claims, recordID) != nil {
+			return owner, true
+		}
+	}
+	return "", false
+}
+
+// Claims reports whether the forward record claims the owner name.
+func (i *PTRIndex) Claims(recordID int, owner string) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return findClaim(i.claims[canonicalName(owner)], recordID) != nil
+}
+
+// Claimants returns the number of forward records claiming the owner name.
+func (i *PTRIndex) Claimants(owner string) int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return len(i.claims[canonicalName(owner)])
+}
+
+// ptrClaimRequest is a claim of a forward record on an owner name.
+type ptrClaimRequest struct {
+	Owner string
+	Claim PTRClaim
+}
+
+// save writes the index to its file. The caller must hold the lock.
+func (i *PTRIndex) save() error {
+	if i.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(i.claims, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(i.path, data)
+}
+
+// ptrTargets returns the PTR targets for a set of claims under the given policy.
+func ptrTargets(claims []PTRClaim, policy string) []string {
+	if len(claims) == 0 {
+		return nil
+	}
+	if policy == PTRPrimaryAll {
+		var targets []string
+		for _, claim := range claims {
+			targets = appendUnique(targets, canonicalName(claim.FQDN))
+		}
+		sort.Strings(targets)
+		return targets
+	}
+	return []string{canonicalName(primaryClaim(claims, policy).FQDN)}
+}
+
+// primaryClaim returns the claim the PTR record points at under the oldest and explicit policies.
+func primaryClaim(claims []PTRClaim, policy string) PTRClaim {
+	sorted := append([]PTRClaim(nil), claims...)
+	sort.SliceStable(sorted, func(a, b int) bool {
+		if !sorted[a].ClaimedAt.Equal(sorted[b].ClaimedAt) {
+			return sorted[a].ClaimedAt.Before(sorted[b].ClaimedAt)
+		}
+		return sorted[a].RecordID < sorted[b].RecordID
+	})
+	if policy == PTRPrimaryExplicit {
+		for _, claim := range sorted {
+			if claim.Primary {
+				return claim
+			}
+		}
+	}
+	return sorted[0]
+}
+
+// primaryClaimTTL returns the TTL of the forward record the PTR RRset follows.
+func primaryClaimTTL(claims []PTRClaim, policy string) *int {
+	if len(claims) == 0 {
+		return nil
+	}
+	return primaryClaim(claims, policy).TTL
+}
+
+// findClaim returns a copy of the claim of recordID, or nil if there is none.
+func findClaim(claims []PTRClaim, recordID int) *PTRClaim {
+	for _, claim := range claims {
+		if claim.RecordID == recordID {
+			found := claim
+			return &found
+		}
+	}
+	return nil
+}
+
+// removeClaim removes the claim of recordID and reports whether it was present.
+func removeClaim(claims []PTRClaim, recordID int) ([]PTRClaim, bool) {
+	found := false
+	result := claims[:0:0]
+	for _, claim := range claims {
+		if claim.RecordID == recordID {
+			found = true
+			continue
+		}
+		result = append(result, claim)
+	}
+	return result, found
+}
+
+// subtractTargets returns the targets in a that are not in b.
+func subtractTargets(a, b []string) []string {
+	var result []string
+	for _, target := range a {
+		if !containsTarget(b, target) {
+			result = append(result, target)
+		}
+	}
+	return result
+}
+
+// appendUnique appends target unless it is already present.
+func appendUnique(targets []string, target string) []string {
+	if containsTarget(targets, target) {
+		return targets
+	}
+	return append(targets, target)
+}
+
+// containsTarget reports whether targets contains target.
+func containsTarget(targets []string, target string) bool {
+	for _, t := range targets {
+		if strings.EqualFold(t, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPTRPrimary reports whether a forward record is flagged as the primary PTR target
+// through the configured custom field.
+func isPTRPrimary(data *RecordData, config *Config) bool {
+	if data == nil || config.PTRPrimaryField == "" {
+		return false
+	}
+	switch v := data.CustomFields[config.PTRPrimaryField].(type) {
+	case bool:
+		return v
+	case string:
+		return strings.EqualFold(v, "true")
+	}
+	return false
+}
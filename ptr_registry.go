@@ -0,0 +1,109 @@
+// ptr_registry.go
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// PTRRegistry is a persistent registry of the PTR records that are managed
+// explicitly in NetBox reverse zones. Explicit PTR records take precedence
+// over PTR records generated from A/AAAA records.
+type PTRRegistry struct {
+	mu       sync.Mutex
+	path     string
+	explicit map[string]string
+}
+
+// explicitPTRs holds the explicit PTR records applied by this service.
+var explicitPTRs = &PTRRegistry{}
+
+// initPTRRegistry loads the explicit PTR registry from the configured file, if it exists.
+func initPTRRegistry(config *Config) error {
+	explicitPTRs.mu.Lock()
+	defer explicitPTRs.mu.Unlock()
+
+	explicitPTRs.path = config.ExplicitPTRFile
+	explicitPTRs.explicit = make(map[string]string)
+	if explicitPTRs.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(explicitPTRs.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read explicit PTR registry: %v", err)
+	}
+	if err := json.Unmarshal(data, &explicitPTRs.explicit); err != nil {
+		return fmt.Errorf("failed to parse explicit PTR registry %s: %v", explicitPTRs.path, err)
+	}
+	return nil
+}
+
+// SetExplicit records an explicit PTR record for the given owner name.
+func (r *PTRRegistry) SetExplicit(owner, target string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.explicit == nil {
+		r.explicit = make(map[string]string)
+	}
+	r.explicit[canonicalName(owner)] = target
+	return r.save()
+}
+
+// RemoveExplicit forgets the explicit PTR record for the given owner name.
+func (r *PTRRegistry) RemoveExplicit(owner string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.explicit, canonicalName(owner))
+	return r.save()
+}
+
+// Explicit returns the target of the explicit PTR record for the given owner name, if any.
+func (r *PTRRegistry) Explicit(owner string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	target, exists := r.explicit[canonicalName(owner)]
+	return target, exists
+}
+
+// save writes the registry to its file. The caller must hold the lock.
+func (r *PTRRegistry) save() error {
+	if r.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(r.explicit, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(r.path, data)
+}
+
+// qualifyPTRTarget makes the target of an explicit PTR record fully qualified.
+// Targets containing a dot are treated as absolute names; single labels are
+// relative to the reverse zone the record belongs to.
+func qualifyPTRTarget(value, fqdn, recordName string) string {
+	value = strings.TrimSpace(value)
+	if strings.HasSuffix(value, ".") {
+		return value
+	}
+	if strings.Contains(value, ".") {
+		return value + "."
+	}
+	return adjustCNAMEValue(value, fqdn, recordName)
+}
+
+// canonicalName returns the lower-case, fully qualified form of a domain name.
+func canonicalName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name != "" && !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
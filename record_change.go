@@ -0,0 +1,330 @@
+// record_change.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+)
+
+// RecordChange is a change of a DNS record, independent of the source it came from.
+// Before is nil for created records and After is nil for deleted records.
+type RecordChange struct {
+	Source    string      `json:"source"` // Name of the source adapter, e.g. "netbox"
+	Event     string      `json:"event"`  // "created", "updated" or "deleted"
+	RequestID string      `json:"request_id"`
+	Username  string      `json:"username"`
+	Timestamp string      `json:"timestamp"`
+	Before    *RecordData `json:"before"`
+	After     *RecordData `json:"after"`
+
+	// deliveryKey is the dedupe cache key of the delivery that carried the change.
+	deliveryKey string
+}
+
+// SourceAdapter decodes the requests of a change source into changes.
+// Sources are served on their own path and share the update pipeline.
+type SourceAdapter interface {
+	// Name identifies the source in logs and responses.
+	Name() string
+	// Decode returns the changes carried by a request body. Objects of the
+	// body that cannot be decoded are returned as changes carrying the error
+	// they are rejected with; an error is returned if the body as a whole is
+	// invalid.
+	Decode(body []byte) ([]*SourceChange, error)
+}
+
+// SourceChange is one change decoded by a source adapter: a record change or,
+// for NetBox sources, a zone change.
+type SourceChange struct {
+	Record *RecordChange
+	Zone   *ZonePayload
+
+	// Scope qualifies the object ID in replay and dedupe keys; it defaults to the source.
+	Scope string
+	// Body is the object as received, stored if the change is quarantined.
+	Body []byte
+	// Err rejects the change. ValidationErrors are reported per field.
+	Err error
+	// Ignored acknowledges the change without applying it, giving the reason.
+	Ignored string
+}
+
+// Event returns the event of the change.
+func (c *SourceChange) Event() string {
+	if c.Zone != nil {
+		return c.Zone.Event
+	}
+	if c.Record != nil {
+		return c.Record.Event
+	}
+	return ""
+}
+
+// RequestID returns the request ID of the change.
+func (c *SourceChange) RequestID() string {
+	if c.Zone != nil {
+		return c.Zone.RequestID
+	}
+	if c.Record != nil {
+		return c.Record.RequestID
+	}
+	return ""
+}
+
+// Timestamp returns the timestamp of the change.
+func (c *SourceChange) Timestamp() string {
+	if c.Zone != nil {
+		return c.Zone.Timestamp
+	}
+	if c.Record != nil {
+		return c.Record.Timestamp
+	}
+	return ""
+}
+
+// ObjectID returns the ID of the changed record or zone.
+func (c *SourceChange) ObjectID() int {
+	if c.Zone != nil {
+		return c.Zone.Zone().ID
+	}
+	if c.Record != nil {
+		return c.Record.RecordID()
+	}
+	return 0
+}
+
+// Validate checks the records of the change for its event.
+func (c *RecordChange) Validate() error {
+	var errs ValidationErrors
+
+	validate := func(prefix string, data *RecordData) {
+		if data == nil {
+			errs.add(prefix, "is required for %s events", c.Event)
+			return
+		}
+		errs.validateRecord(prefix, data.FQDN, data.Type, data.Value, data.TTL)
+	}
+
+	switch c.Event {
+	case "":
+		errs.add("event", "is required")
+	case "created":
+		validate("after", c.After)
+	case "deleted":
+		validate("before", c.Before)
+	case "updated":
+		validate("after", c.After)
+		if c.Before != nil {
+			validate("before", c.Before)
+		}
+	default:
+		errs.add("event", "unsupported event type %q", c.Event)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// normalizeIDN converts the internationalized names of the change to their ASCII form.
+func (c *RecordChange) normalizeIDN() error {
+	for _, side := range []struct {
+		prefix string
+		data   *RecordData
+	}{{"before", c.Before}, {"after", c.After}} {
+		if side.data == nil {
+			continue
+		}
+		data := side.data
+		if err := normalizeRecordIDN(side.prefix, &data.FQDN, &data.Name, &data.Value, data.Type); err != nil {
+			return err
+		}
+		if err := convertIDN(side.prefix+".zone.name", &data.Zone.Name, toASCIIName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsManagedPTR reports whether the change is of a PTR record managed by NetBox DNS.
+func (c *RecordChange) IsManagedPTR() bool {
+	for _, data := range []*RecordData{c.Before, c.After} {
+		if data != nil && data.Managed && strings.EqualFold(data.Type, "PTR") {
+			return true
+		}
+	}
+	return false
+}
+
+// RecordID returns the ID of the changed record.
+func (c *RecordChange) RecordID() int {
+	if c.After != nil {
+		return c.After.ID
+	}
+	if c.Before != nil {
+		return c.Before.ID
+	}
+	return 0
+}
+
+// syntheticRecordID derives a stable record ID from the source, owner name and
+// type for sources without record IDs. Synthetic IDs are negative, so they
+// never collide with NetBox record IDs.
+func syntheticRecordID(source, fqdn, recordType string) int {
+	h := fnv.New32a()
+	h.Write([]byte(source + "|" + canonicalName(fqdn) + "|" + recordType))
+	return -int(h.Sum32()&0x7fffffff) - 1
+}
+
+// applyRecordChange runs a record change through the update pipeline and responds.
+func applyRecordChange(w http.ResponseWriter, config *Config, lockManager *RecordLockManager, change *RecordChange) {
+	// PTR records managed by NetBox DNS are only applied when NetBox is the PTR source
+	if change.IsManagedPTR() && config.PTRSource != PTRSourceNetBox {
+		logInfo("Ignoring PTR record managed by NetBox DNS",
+			"event", change.Event,
+			"source", change.Source,
+			"ptr_source", config.PTRSource,
+			"request_id", change.RequestID,
+			"record_id", change.RecordID(),
+		)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Managed PTR record ignored"))
+		return
+	}
+
+	switch change.Event {
+	case "created":
+		handleCreatedEvent(w, config, lockManager, change)
+	case "deleted":
+		handleDeletedEvent(w, config, lockManager, change)
+	case "updated":
+		handleUpdatedEvent(w, config, lockManager, change)
+	default:
+		logError("Unsupported event type", "event", change.Event, "source", change.Source)
+		writeProblem(w, http.StatusBadRequest, fmt.Sprintf("Unsupported event type %q", change.Event))
+	}
+}
+
+// sourceWebhookHandler handles the requests of a change source.
+func sourceWebhookHandler(w http.ResponseWriter, r *http.Request, adapter SourceAdapter, config *Config, lockManager *RecordLockManager) {
+	serveWebhook(w, r, config, func(w http.ResponseWriter, body []byte) {
+		handleSourcePayload(w, r, adapter, body, config, lockManager)
+	})
+}
+
+// handleSourcePayload decodes a request body with the adapter and runs its
+// changes through the update pipeline. Bodies that are JSON arrays or carry
+// several changes are answered with the result per change.
+func handleSourcePayload(w http.ResponseWriter, r *http.Request, adapter SourceAdapter, body []byte, config *Config, lockManager *RecordLockManager) {
+	changes, err := adapter.Decode(body)
+	if err != nil {
+		logError("Rejected change source payload", "source", adapter.Name(), "err", err, "client_subject", clientSubject(r))
+		if _, ok := err.(ValidationErrors); ok {
+			writeValidationErrors(w, err)
+			return
+		}
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(changes) == 0 {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("No record changes in payload"))
+		return
+	}
+
+	// Validate the record changes and convert internationalized names
+	for _, change := range changes {
+		if change.Err == nil && change.Record != nil {
+			if change.Err = change.Record.Validate(); change.Err == nil {
+				change.Err = change.Record.normalizeIDN()
+			}
+		}
+	}
+
+	if trimmed := bytes.TrimSpace(body); len(changes) == 1 && (len(trimmed) == 0 || trimmed[0] != '[') {
+		applySourceChange(w, r, adapter, changes[0], config, lockManager)
+		return
+	}
+	rejected := respondPerObject(w, len(changes), func(i int, w http.ResponseWriter) {
+		applySourceChange(w, r, adapter, changes[i], config, lockManager)
+	})
+	logInfo("Processed change source payload",
+		"source", adapter.Name(),
+		"changes", len(changes),
+		"rejected", rejected,
+		"client_subject", clientSubject(r),
+	)
+}
+
+// applySourceChange runs one decoded change through replay protection,
+// deduplication and the update pipeline, and responds.
+func applySourceChange(w http.ResponseWriter, r *http.Request, adapter SourceAdapter, change *SourceChange, config *Config, lockManager *RecordLockManager) {
+	if change.Err != nil {
+		logError("Rejected change",
+			"source", adapter.Name(),
+			"err", change.Err,
+			"request_id", change.RequestID(),
+			"object_id", change.ObjectID(),
+			"client_subject", clientSubject(r),
+		)
+		if _, ok := change.Err.(ValidationErrors); ok {
+			writeValidationErrors(w, change.Err)
+			return
+		}
+		writeProblem(w, http.StatusBadRequest, change.Err.Error())
+		return
+	}
+	if change.Ignored != "" {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(change.Ignored))
+		return
+	}
+
+	scope := change.Scope
+	if scope == "" {
+		scope = "source:" + adapter.Name()
+	}
+	keyvals := []interface{}{
+		"source", adapter.Name(),
+		"scope", scope,
+		"event", change.Event(),
+		"object_id", change.ObjectID(),
+		"request_id", change.RequestID(),
+	}
+
+	// Reject replayed changes and ignore changes older than the newest applied change
+	object := ""
+	if change.ObjectID() != 0 {
+		object = fmt.Sprintf("%s|%d", scope, change.ObjectID())
+	}
+	body := change.Body
+	if body == nil {
+		body, _ = json.Marshal(change.Record)
+	}
+	w, applied, ok := guardReplay(w, r, object, change.Timestamp(), body, config, keyvals...)
+	if !ok {
+		return
+	}
+	defer applied()
+
+	// Acknowledge duplicate deliveries without applying them again
+	key := deliveryKey(change.RequestID(), scope, change.ObjectID(), change.Event(), change.Timestamp())
+	w, done, ok := acceptDelivery(w, r, key, append(keyvals, "timestamp", change.Timestamp())...)
+	if !ok {
+		return
+	}
+	defer done()
+
+	if change.Zone != nil {
+		handleZoneEvent(w, change.Zone, key, config, lockManager)
+		return
+	}
+	change.Record.deliveryKey = key
+	applyRecordChange(w, config, lockManager, change.Record)
+}
@@ -0,0 +1,111 @@
+// record_index.go
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// ManagedRecord is a forward record the service has applied to the DNS server.
+type ManagedRecord struct {
+	RecordID int    `json:"record_id"`
+	ZoneID   int    `json:"zone_id"`
+	FQDN     string `json:"fqdn"`
+	Type     string `json:"type"`
+	Value    string `json:"value"`
+	PTR      bool   `json:"ptr,omitempty"` // Set if a PTR record was generated for the record
+}
+
+// RecordIndex is a persistent index of the records the service manages, used
+// to remove the records of a deleted zone.
+type RecordIndex struct {
+	mu      sync.Mutex
+	path    string
+	records map[int]ManagedRecord
+}
+
+// recordIndex holds the records applied by this service.
+var recordIndex = &RecordIndex{}
+
+// initRecordIndex loads the record index from the configured file, if it exists.
+func initRecordIndex(config *Config) error {
+	recordIndex.mu.Lock()
+	defer recordIndex.mu.Unlock()
+
+	recordIndex.path = config.RecordIndexFile
+	recordIndex.records = make(map[int]ManagedRecord)
+	if recordIndex.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(recordIndex.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read record index: %v", err)
+	}
+	if err := json.Unmarshal(data, &recordIndex.records); err != nil {
+		return fmt.Errorf("failed to parse record index %s: %v", recordIndex.path, err)
+	}
+	return nil
+}
+
+// Put stores or replaces a managed record.
+func (i *RecordIndex) Put(record ManagedRecord) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.records == nil {
+		i.records = make(map[int]ManagedRecord)
+	}
+	i.records[record.RecordID] = record
+	return i.save()
+}
+
+// Remove forgets managed records.
+func (i *RecordIndex) Remove(recordIDs ...int) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for _, recordID := range recordIDs {
+		delete(i.records, recordID)
+	}
+	return i.save()
+}
+
+// Get returns the managed record with the record ID.
+func (i *RecordIndex) Get(recordID int) (ManagedRecord, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	record, exists := i.records[recordID]
+	return record, exists
+}
+
+// InZone returns the managed records of a zone, ordered by record ID.
+func (i *RecordIndex) InZone(zoneID int) []ManagedRecord {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	var records []ManagedRecord
+	for _, record := range i.records {
+		if record.ZoneID == zoneID {
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(a, b int) bool { return records[a].RecordID < records[b].RecordID })
+	return records
+}
+
+// save writes the index to its file. The caller must hold the lock.
+func (i *RecordIndex) save() error {
+	if i.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(i.records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(i.path, data)
+}
@@ -2,7 +2,10 @@
 
 package main
 
-import "sync"
+import (
+	"sort"
+	"sync"
+)
 
 // RecordLockManager manages locks for DNS records to prevent race conditions.
 type RecordLockManager struct {
@@ -33,3 +36,32 @@ func (rlm *RecordLockManager) ReleaseLock(key string) {
 		lock.Unlock()
 	}
 }
+
+// AcquireLocks acquires the locks for several keys in a fixed order to avoid deadlocks.
+// Duplicate and empty keys are ignored.
+func (rlm *RecordLockManager) AcquireLocks(keys ...string) {
+	for _, key := range uniqueLockKeys(keys) {
+		rlm.AcquireLock(key)
+	}
+}
+
+// ReleaseLocks releases the locks acquired with AcquireLocks.
+func (rlm *RecordLockManager) ReleaseLocks(keys ...string) {
+	for _, key := range uniqueLockKeys(keys) {
+		rlm.ReleaseLock(key)
+	}
+}
+
+// uniqueLockKeys returns the sorted, de-duplicated, non-empty keys.
+func uniqueLockKeys(keys []string) []string {
+	var unique []string
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if key != "" && !seen[key] {
+			seen[key] = true
+			unique = append(unique, key)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
@@ -0,0 +1,277 @@
+// replay_guard.go
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Actions for webhooks outside the replay window.
+const (
+	// ReplayReject rejects webhooks outside the replay window with 403.
+	ReplayReject = "reject"
+	// ReplayQuarantine stores webhooks outside the replay window in the quarantine directory for review.
+	ReplayQuarantine = "quarantine"
+)
+
+// payloadTimestampLayouts are the timestamp formats of NetBox webhooks
+// (ISO 8601 with "T" or, in older releases, a space separator).
+var payloadTimestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+}
+
+// replayStateSaveDelay is how long changes to the replay state are collected
+// before the state is written, so that bulk operations do not rewrite the
+// file for every change.
+const replayStateSaveDelay = time.Second
+
+// ReplayGuard remembers the timestamp of the newest change applied per object,
+// so that older changes arriving late are recognized as stale. The timestamps
+// are persisted, so that stale retries are recognized after a restart, and
+// forgotten after the retention period.
+type ReplayGuard struct {
+	mu        sync.Mutex
+	saveMu    sync.Mutex // Serializes writes of the state file
+	path      string
+	retention time.Duration
+	newest    map[string]time.Time
+	pending   bool // Set while a save is scheduled
+}
+
+// replayGuard is the global guard against replayed and stale webhooks.
+var replayGuard = &ReplayGuard{newest: make(map[string]time.Time)}
+
+// initReplayGuard creates the quarantine directory and loads the replay state
+// from the configured file, if it exists. Timestamps are kept for the replay
+// window, as older changes are rejected by the window check, or for
+// ReplayStateRetention if the window is disabled.
+func initReplayGuard(config *Config) error {
+	if config.ReplayAction == ReplayQuarantine {
+		if err := os.MkdirAll(config.ReplayQuarantineDir, 0o750); err != nil {
+			return fmt.Errorf("failed to create quarantine directory: %v", err)
+		}
+	}
+
+	replayGuard.mu.Lock()
+	defer replayGuard.mu.Unlock()
+
+	replayGuard.path = config.ReplayStateFile
+	replayGuard.retention = time.Duration(config.ReplayStateRetention) * time.Second
+	if config.ReplayWindow > 0 {
+		replayGuard.retention = time.Duration(config.ReplayWindow) * time.Second
+	}
+	replayGuard.newest = make(map[string]time.Time)
+	if replayGuard.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(replayGuard.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read replay state: %v", err)
+	}
+	if err := json.Unmarshal(data, &replayGuard.newest); err != nil {
+		return fmt.Errorf("failed to parse replay state %s: %v", replayGuard.path, err)
+	}
+	return nil
+}
+
+// parsePayloadTimestamp parses the timestamp of a payload.
+func parsePayloadTimestamp(timestamp string) (time.Time, error) {
+	timestamp = strings.TrimSpace(timestamp)
+	if timestamp == "" {
+		return time.Time{}, fmt.Errorf("missing timestamp")
+	}
+	for _, layout := range payloadTimestampLayouts {
+		if t, err := time.Parse(layout, timestamp); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q", timestamp)
+}
+
+// checkReplayWindow returns an error if the timestamp is missing or further
+// than the replay window from the current time, in either direction.
+func checkReplayWindow(timestamp string, config *Config) error {
+	t, err := parsePayloadTimestamp(timestamp)
+	if err != nil {
+		return err
+	}
+	window := time.Duration(config.ReplayWindow) * time.Second
+	if skew := time.Since(t); skew > window || skew < -window {
+		return fmt.Errorf("timestamp %s is %s away from the current time, outside the replay window of %s",
+			timestamp, skew.Round(time.Second), window)
+	}
+	return nil
+}
+
+// Claim checks a change with timestamp t against the newest change applied
+// to the object and records t if the change is not stale, in one step, so
+// that concurrent changes to the object are ordered. It returns the timestamp
+// of the newest change before the claim and whether the change is stale.
+func (g *ReplayGuard) Claim(object string, t time.Time) (time.Time, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	newest, exists := g.newest[object]
+	if exists && t.Before(newest) {
+		return newest, true
+	}
+	if !t.After(newest) {
+		return newest, false
+	}
+	g.newest[object] = t
+	g.scheduleSave()
+	return newest, false
+}
+
+// Release reverts the claim of a change with timestamp t that was rejected,
+// restoring the previous newest timestamp unless a newer change was claimed since.
+func (g *ReplayGuard) Release(object string, t, previous time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.newest[object].Equal(t) {
+		return
+	}
+	if previous.IsZero() {
+		delete(g.newest, object)
+	} else {
+		g.newest[object] = previous
+	}
+	g.scheduleSave()
+}
+
+// scheduleSave writes the replay state after replayStateSaveDelay, unless a
+// save is already scheduled. The caller must hold the lock.
+func (g *ReplayGuard) scheduleSave() {
+	if g.path == "" || g.pending {
+		return
+	}
+	g.pending = true
+	time.AfterFunc(replayStateSaveDelay, func() {
+		if err := g.save(); err != nil {
+			logError("Failed to persist replay state", "err", err, "file", g.path)
+		}
+	})
+}
+
+// save removes the timestamps older than the retention period and writes the
+// replay state to its file.
+func (g *ReplayGuard) save() error {
+	g.saveMu.Lock()
+	defer g.saveMu.Unlock()
+
+	g.mu.Lock()
+	g.pending = false
+	if g.retention > 0 {
+		cutoff := time.Now().Add(-g.retention)
+		for object, newest := range g.newest {
+			if newest.Before(cutoff) {
+				delete(g.newest, object)
+			}
+		}
+	}
+	data, err := json.MarshalIndent(g.newest, "", "  ")
+	g.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(g.path, data)
+}
+
+// guardReplay checks a change to object against the replay window and the
+// newest change applied to the object. Changes outside the window are rejected
+// or quarantined; stale changes are acknowledged without being applied (and
+// quarantined if so configured). In both cases false is returned. Otherwise it
+// returns the ResponseWriter to handle the change with and a function to call
+// once it was handled, which releases the timestamp claimed for the change if
+// the change was rejected.
+func guardReplay(w http.ResponseWriter, r *http.Request, object, timestamp string, body []byte, config *Config, keyvals ...interface{}) (http.ResponseWriter, func(), bool) {
+	keyvals = append(keyvals, "timestamp", timestamp, "remote_addr", r.RemoteAddr)
+
+	if config.ReplayWindow > 0 {
+		if err := checkReplayWindow(timestamp, config); err != nil {
+			if config.ReplayAction == ReplayQuarantine {
+				file, qerr := quarantinePayload(body, "window", config)
+				if qerr == errQuarantineFull {
+					logWarn("Rejected webhook outside the replay window, quarantine directory is full", append(keyvals, "err", err)...)
+					writeProblem(w, http.StatusForbidden, err.Error())
+					return w, nil, false
+				}
+				if qerr != nil {
+					logError("Failed to quarantine webhook outside the replay window", append(keyvals, "err", qerr)...)
+					writeProblem(w, http.StatusInternalServerError, "Failed to quarantine webhook")
+					return w, nil, false
+				}
+				logWarn("Quarantined webhook outside the replay window", append(keyvals, "err", err, "file", file)...)
+				w.WriteHeader(http.StatusAccepted)
+				w.Write([]byte("Webhook quarantined"))
+				return w, nil, false
+			}
+			logWarn("Rejected webhook outside the replay window", append(keyvals, "err", err)...)
+			writeProblem(w, http.StatusForbidden, err.Error())
+			return w, nil, false
+		}
+	}
+
+	// Changes without an object ID or a usable timestamp cannot be ordered
+	t, err := parsePayloadTimestamp(timestamp)
+	if object == "" || err != nil {
+		return w, func() {}, true
+	}
+
+	previous, stale := replayGuard.Claim(object, t)
+	if stale {
+		keyvals = append(keyvals, "newest_applied", previous.Format(time.RFC3339Nano))
+		if config.ReplayAction == ReplayQuarantine {
+			file, err := quarantinePayload(body, "stale", config)
+			if err == errQuarantineFull {
+				logWarn("Not quarantining stale webhook, quarantine directory is full", keyvals...)
+			} else if err != nil {
+				logError("Failed to quarantine stale webhook", append(keyvals, "err", err)...)
+			} else {
+				keyvals = append(keyvals, "file", file)
+			}
+		}
+		logWarn("Ignoring stale webhook", keyvals...)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Stale webhook ignored"))
+		return w, nil, false
+	}
+
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	return rec, func() {
+		if rec.status >= http.StatusMultipleChoices {
+			replayGuard.Release(object, t, previous)
+		}
+	}, true
+}
+
+// errQuarantineFull is returned by quarantinePayload if the quarantine
+// directory holds the configured maximum number of payloads.
+var errQuarantineFull = fmt.Errorf("quarantine directory is full")
+
+// quarantinePayload stores a payload in the quarantine directory and returns the file name.
+// Payloads are not stored once the directory holds ReplayQuarantineMaxFiles payloads.
+func quarantinePayload(body []byte, reason string, config *Config) (string, error) {
+	if config.ReplayQuarantineMaxFiles > 0 {
+		files, err := filepath.Glob(filepath.Join(config.ReplayQuarantineDir, "*.json"))
+		if err != nil {
+			return "", err
+		}
+		if len(files) >= config.ReplayQuarantineMaxFiles {
+			return "", errQuarantineFull
+		}
+	}
+	file := filepath.Join(config.ReplayQuarantineDir, fmt.Sprintf("%s-%s-%s.json", time.Now().UTC().Format("20060102T150405Z"), reason, newJobID()))
+	return file, writeFileAtomic(file, body)
+}
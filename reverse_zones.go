@@ -0,0 +1,316 @@
+// reverse_zones.go
+
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ptrOwnerName returns the owner name of the PTR record for an IP address.
+// IPv4 addresses covered by an RFC 2317 classless delegation are placed in the
+// delegated zone; all other addresses use the standard reverse name.
+func ptrOwnerName(ip string, config *Config) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+
+	if zone := classlessReverseZone(addr, config); zone != nil {
+		octets := addr.As4()
+		return fmt.Sprintf("%d.%s", octets[3], zone.Zone)
+	}
+
+	return reverseDNSName(ip)
+}
+
+// PTR skip reasons for addresses that never get a PTR record.
+const (
+	ptrSkipIPv4Mapped  = "ipv4_mapped"
+	ptrSkipLinkLocal   = "link_local"
+	ptrSkipUniqueLocal = "unique_local"
+	ptrSkipLoopback    = "loopback"
+	ptrSkipMulticast   = "multicast"
+	ptrSkipUnspecified = "unspecified"
+)
+
+// ptrAddress applies the PTR address policy to ip. It returns the address to
+// create the PTR record for, or an empty address and the reason it is skipped.
+func ptrAddress(ip string, config *Config) (string, string) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", ""
+	}
+
+	if addr.Is4In6() {
+		switch config.IPv4MappedPTR {
+		case IPv4MappedIPv4:
+			addr = addr.Unmap()
+		case IPv4MappedIPv6:
+		default:
+			return "", ptrSkipIPv4Mapped
+		}
+	}
+
+	switch {
+	case addr.IsUnspecified():
+		return "", ptrSkipUnspecified
+	case addr.IsLoopback():
+		return "", ptrSkipLoopback
+	case addr.IsLinkLocalUnicast():
+		return "", ptrSkipLinkLocal
+	case addr.IsMulticast():
+		return "", ptrSkipMulticast
+	case addr.Is6() && addr.IsPrivate() && config.SkipULAPTR:
+		return "", ptrSkipUniqueLocal
+	}
+	return addr.String(), ""
+}
+
+// classlessReverseZone returns the most specific classless delegation covering addr, if any.
+func classlessReverseZone(addr netip.Addr, config *Config) *ClasslessReverseZone {
+	if !addr.Is4() {
+		return nil
+	}
+
+	var best *ClasslessReverseZone
+	for i := range config.ClasslessReverseZones {
+		zone := &config.ClasslessReverseZones[i]
+		if zone.prefix.Contains(addr) && (best == nil || zone.prefix.Bits() > best.prefix.Bits()) {
+			best = zone
+		}
+	}
+	return best
+}
+
+// ptrTTL returns the TTL for an auto-generated PTR record.
+// A TTL configured for the reverse zone takes precedence over the forward record's TTL;
+// the result is clamped to the configured minimum and maximum.
+func ptrTTL(ptrName string, forwardTTL *int, config *Config) int {
+	policy := config.ReverseTTL
+
+	ttl := 300
+	if forwardTTL != nil && *forwardTTL > 0 {
+		ttl = *forwardTTL
+	}
+
+	// Use the TTL of the most specific matching reverse zone
+	bestZone := ""
+	for zone, zoneTTL := range policy.Zones {
+		if isSubdomain(ptrName, zone) && len(canonicalName(zone)) > len(bestZone) {
+			bestZone = canonicalName(zone)
+			ttl = zoneTTL
+		}
+	}
+
+	if policy.MinTTL > 0 && ttl < policy.MinTTL {
+		ttl = policy.MinTTL
+	}
+	if policy.MaxTTL > 0 && ttl > policy.MaxTTL {
+		ttl = policy.MaxTTL
+	}
+	return ttl
+}
+
+// isSubdomain reports whether name is equal to or below zone.
+func isSubdomain(name, zone string) bool {
+	name = canonicalName(name)
+	zone = canonicalName(zone)
+	return name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+// reverseZoneName derives the reverse zone name for a prefix on an octet (IPv4)
+// or nibble (IPv6) boundary. It returns an empty string for other prefixes.
+func reverseZoneName(prefix netip.Prefix) string {
+	labels := strings.Split(strings.TrimSuffix(reverseDNSName(prefix.Addr().String()), "."), ".")
+
+	// Number of address labels in the reverse name and bits per label
+	addressLabels, bitsPerLabel := 4, 8
+	if prefix.Addr().Is6() {
+		addressLabels, bitsPerLabel = 32, 4
+	}
+	if prefix.Bits() == 0 || prefix.Bits()%bitsPerLabel != 0 || len(labels) < addressLabels {
+		return ""
+	}
+
+	keep := prefix.Bits() / bitsPerLabel
+	return strings.Join(labels[addressLabels-keep:], ".") + "."
+}
+
+// reverseZoneFor returns the reverse zone holding the PTR record ptrName for ip.
+// The second result is false if the DNS server is not authoritative for it.
+// Without configured reverse zones or discovery, every address is assumed to be served.
+func reverseZoneFor(ip, ptrName string, config *Config) (string, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", false
+	}
+
+	// Classless delegations are always configured explicitly
+	if zone := classlessReverseZone(addr, config); zone != nil {
+		return zone.Zone, true
+	}
+
+	if len(config.ReverseZones) > 0 {
+		// Prefer the most specific prefix, then the longest matching zone name
+		var best *ReverseZone
+		for i := range config.ReverseZones {
+			zone := &config.ReverseZones[i]
+			if zone.prefix.IsValid() {
+				if zone.prefix.Contains(addr) && (best == nil || !best.prefix.IsValid() || zone.prefix.Bits() > best.prefix.Bits()) {
+					best = zone
+				}
+			} else if isSubdomain(ptrName, zone.Zone) && (best == nil || (!best.prefix.IsValid() && len(zone.Zone) > len(best.Zone))) {
+				best = zone
+			}
+		}
+		if best == nil {
+			return "", false
+		}
+		return best.Zone, true
+	}
+
+	if config.ReverseZoneDiscovery {
+		return reverseZoneCache.Lookup(ptrName, config)
+	}
+
+	return "", true
+}
+
+// ReverseZoneCache caches reverse zones discovered via SOA lookups.
+type ReverseZoneCache struct {
+	mu      sync.Mutex
+	zones   map[string]time.Time // Authoritative zones and their expiry
+	missing map[string]time.Time // Names without an authoritative zone and their expiry
+}
+
+// reverseZoneCache holds the reverse zones discovered by this service.
+var reverseZoneCache = &ReverseZoneCache{}
+
+// Lookup returns the authoritative zone for ptrName, querying the SOA if it is not cached.
+// Query failures are logged and treated as authoritative so the server can decide.
+func (c *ReverseZoneCache) Lookup(ptrName string, config *Config) (string, bool) {
+	now := time.Now()
+	missingKey := negativeCacheKey(ptrName)
+
+	c.mu.Lock()
+	bestZone := ""
+	for zone, expiry := range c.zones {
+		if now.Before(expiry) && isSubdomain(ptrName, zone) && len(zone) > len(bestZone) {
+			bestZone = zone
+		}
+	}
+	missingExpiry, isMissing := c.missing[missingKey]
+	c.mu.Unlock()
+
+	if bestZone != "" {
+		return bestZone, true
+	}
+	if isMissing && now.Before(missingExpiry) {
+		return "", false
+	}
+
+	if isSubdomain(ptrName, "ip6.arpa.") && len(config.IPv6ReverseZoneLengths) > 0 {
+		return c.probeIPv6ZoneCuts(ptrName, config)
+	}
+
+	response, err := QueryDNS(ptrName, "SOA", config)
+	if err != nil {
+		logWarn("Reverse zone discovery failed", "ptr", ptrName, "err", err)
+		return "", true
+	}
+
+	zone := ""
+	if response.Authoritative && (response.Status == "NOERROR" || response.Status == "NXDOMAIN") {
+		for _, rr := range append(response.Answer, response.Authority...) {
+			if rr.Type == "SOA" && isSubdomain(ptrName, rr.Name) {
+				zone = canonicalName(rr.Name)
+				break
+			}
+		}
+	}
+
+	if zone == "" {
+		c.store(missingKey, false, config)
+		return "", false
+	}
+	c.store(zone, true, config)
+	logDebug("Discovered reverse zone", "zone", zone, "ptr", ptrName)
+	return zone, true
+}
+
+// probeIPv6ZoneCuts looks for an SOA at the configured ip6.arpa zone cuts of
+// ptrName, from the most to the least specific prefix length.
+func (c *ReverseZoneCache) probeIPv6ZoneCuts(ptrName string, config *Config) (string, bool) {
+	lengths := append([]int(nil), config.IPv6ReverseZoneLengths...)
+	sort.Sort(sort.Reverse(sort.IntSlice(lengths)))
+
+	// A full ip6.arpa name has 32 nibble labels followed by "ip6" and "arpa"
+	labels := strings.Split(strings.TrimSuffix(canonicalName(ptrName), "."), ".")
+	if len(labels) != 32+2 {
+		return "", false
+	}
+	for _, length := range lengths {
+		nibbles := length / 4
+		candidate := strings.Join(labels[32-nibbles:], ".") + "."
+
+		c.mu.Lock()
+		expiry, isMissing := c.missing[candidate]
+		c.mu.Unlock()
+		if isMissing && time.Now().Before(expiry) {
+			continue
+		}
+
+		response, err := QueryDNS(candidate, "SOA", config)
+		if err != nil {
+			logWarn("Reverse zone discovery failed", "ptr", ptrName, "zone", candidate, "err", err)
+			return "", true
+		}
+		for _, rr := range response.Answer {
+			if response.Authoritative && rr.Type == "SOA" && sameName(rr.Name, candidate) {
+				c.store(candidate, true, config)
+				logDebug("Discovered reverse zone", "zone", candidate, "ptr", ptrName, "prefix_length", length)
+				return candidate, true
+			}
+		}
+		c.store(candidate, false, config)
+	}
+	return "", false
+}
+
+// store caches a discovered zone or a name without an authoritative zone.
+func (c *ReverseZoneCache) store(name string, authoritative bool, config *Config) {
+	expiry := time.Now().Add(time.Duration(config.ReverseZoneCacheTTL) * time.Second)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if authoritative {
+		if c.zones == nil {
+			c.zones = make(map[string]time.Time)
+		}
+		c.zones[name] = expiry
+		return
+	}
+	if c.missing == nil {
+		c.missing = make(map[string]time.Time)
+	}
+	c.missing[name] = expiry
+}
+
+// negativeCacheKey returns the name under which a failed zone lookup is cached:
+// the /24 reverse name for IPv4 and the /64 reverse name for IPv6.
+func negativeCacheKey(ptrName string) string {
+	labels := strings.Split(canonicalName(ptrName), ".")
+	strip := 1
+	if isSubdomain(ptrName, "ip6.arpa.") {
+		strip = 16
+	}
+	if len(labels) <= strip {
+		return canonicalName(ptrName)
+	}
+	return strings.Join(labels[strip:], ".")
+}
@@ -0,0 +1,122 @@
+// source_allowlist.go
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// allowSources wraps a handler and rejects requests whose source address is
+// outside the allowlist configured for the endpoint. Endpoints without an
+// allowlist are open.
+func allowSources(path string, config *Config, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		allowed, restricted := config.allowedSources[path]
+		if !restricted {
+			next(w, r)
+			return
+		}
+
+		source, err := clientIP(r, config)
+		if err != nil {
+			logWarn("Rejected request with unknown source address",
+				"path", path,
+				"err", err,
+				"remote_addr", r.RemoteAddr,
+				"forwarded_for", r.Header.Get("X-Forwarded-For"),
+			)
+			writeProblem(w, http.StatusForbidden, "Source address not allowed")
+			return
+		}
+		if !prefixesContain(allowed, source) {
+			logWarn("Rejected request from source outside allowlist",
+				"path", path,
+				"source", source,
+				"remote_addr", r.RemoteAddr,
+				"forwarded_for", r.Header.Get("X-Forwarded-For"),
+			)
+			writeProblem(w, http.StatusForbidden, "Source address not allowed")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// clientIP returns the source address of the request. The X-Forwarded-For
+// header is only honored if the request comes from a trusted proxy; it is
+// walked from right to left, skipping further trusted proxies. Requests from
+// a trusted proxy without a valid X-Forwarded-For header are an error, as the
+// proxy's own address says nothing about the client.
+func clientIP(r *http.Request, config *Config) (netip.Addr, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("invalid remote address %q: %v", r.RemoteAddr, err)
+	}
+	remote = remote.Unmap()
+
+	if !prefixesContain(config.trustedProxies, remote) {
+		return remote, nil
+	}
+
+	header := strings.Join(r.Header.Values("X-Forwarded-For"), ",")
+	if strings.TrimSpace(header) == "" {
+		return netip.Addr{}, fmt.Errorf("missing X-Forwarded-For header from trusted proxy %s", remote)
+	}
+	forwarded := strings.Split(header, ",")
+	var client netip.Addr
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			return netip.Addr{}, fmt.Errorf("invalid X-Forwarded-For entry %q from trusted proxy %s", strings.TrimSpace(forwarded[i]), remote)
+		}
+		client = addr.Unmap()
+		if !prefixesContain(config.trustedProxies, client) {
+			break
+		}
+	}
+	return client, nil
+}
+
+// prefixesContain reports whether any of the prefixes contains addr.
+func prefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePrefixes parses a list of CIDRs; single addresses are treated as host prefixes.
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if !strings.Contains(value, "/") {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
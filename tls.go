@@ -0,0 +1,169 @@
+// tls.go
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// certReloader serves a certificate and key pair and reloads it when either file changes on disk.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modTimes [2]time.Time
+}
+
+// newCertReloader loads the certificate and key pair.
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// GetCertificate returns the current certificate, reloading it first if the files have changed.
+// If reloading fails, the previous certificate is kept.
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if modTimes, err := c.statFiles(); err == nil && modTimes != c.modTimes {
+		if err := c.reloadLocked(); err != nil {
+			logError("Failed to reload TLS certificate, keeping the previous one", "err", err, "cert_file", c.certFile)
+		} else {
+			logInfo("Reloaded TLS certificate", "cert_file", c.certFile)
+		}
+	}
+	return c.cert, nil
+}
+
+// reload loads the certificate and key pair from disk.
+func (c *certReloader) reload() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reloadLocked()
+}
+
+// reloadLocked loads the certificate and key pair from disk. The caller must hold the lock.
+func (c *certReloader) reloadLocked() error {
+	modTimes, err := c.statFiles()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+	c.cert = &cert
+	c.modTimes = modTimes
+	return nil
+}
+
+// statFiles returns the modification times of the certificate and key files.
+func (c *certReloader) statFiles() ([2]time.Time, error) {
+	var modTimes [2]time.Time
+	for i, file := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return modTimes, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// newTLSConfig builds the TLS configuration of the listener. If a client CA
+// bundle is configured, client certificates are verified against it and, if
+// configured, must carry one of the allowed names. Presenting a certificate
+// is optional during the handshake, so that health checks work without one;
+// requireClientCert enforces it on the webhook endpoints.
+func newTLSConfig(config *Config) (*tls.Config, error) {
+	reloader, err := newCertReloader(config.TLSCertFile, config.TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	if config.TLSClientCAFile != "" {
+		caBundle, err := os.ReadFile(config.TLSClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA bundle: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf("no certificates found in client CA bundle %s", config.TLSClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	if len(config.TLSAllowedClientNames) > 0 {
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return nil
+			}
+			if !clientNameAllowed(state.PeerCertificates[0], config.TLSAllowedClientNames) {
+				logWarn("Rejected TLS client certificate", "client_subject", state.PeerCertificates[0].Subject.String())
+				return fmt.Errorf("client certificate %s is not allowed", state.PeerCertificates[0].Subject)
+			}
+			return nil
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// requireClientCert wraps a handler and rejects requests without a verified
+// client certificate if client certificates are configured.
+func requireClientCert(config *Config, next http.HandlerFunc) http.HandlerFunc {
+	if config.TLSClientCAFile == "" {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			logWarn("Rejected request without client certificate",
+				"path", r.URL.Path,
+				"remote_addr", r.RemoteAddr,
+			)
+			writeProblem(w, http.StatusForbidden, "Client certificate required")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// clientNameAllowed reports whether the common name or a DNS name of cert is in allowed.
+func clientNameAllowed(cert *x509.Certificate, allowed []string) bool {
+	names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	for _, name := range names {
+		for _, allowedName := range allowed {
+			if name != "" && strings.EqualFold(name, allowedName) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// clientSubject returns the subject of the verified client certificate, if any.
+func clientSubject(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+	return r.TLS.PeerCertificates[0].Subject.String()
+}
@@ -0,0 +1,347 @@
+// update_batcher.go
+
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxUpdatesPerMessage caps the update lines merged into one UPDATE message
+// so that batched messages stay well below the 64 KiB DNS message size.
+const maxUpdatesPerMessage = 200
+
+// updateJob is a DNS change of one record waiting to be sent to the DNS server.
+type updateJob struct {
+	// Locks are the lock keys held while the script is built and executed.
+	Locks []string
+	// Zone is the zone all updates of the script belong to, if known.
+	// Only jobs with a known zone are merged into shared UPDATE messages.
+	Zone string
+	// Check, if set, runs once the locks are held and rejects the job with an
+	// error. It sees the changes of the jobs of the batch that run before it.
+	Check func(scheduled *scheduledChanges) error
+	// Planned, if set, is the nsupdate script of the job as known when it is
+	// queued. It lets the checks of later changes of the same request ID see
+	// the change before it is sent.
+	Planned string
+	// Script builds the nsupdate script once the locks are held.
+	// An empty script means there is nothing to send.
+	Script func() string
+	// Done is called with the result of the update. Successful updates are
+	// logged with logProcessed, which is logDebug for jobs run as part of a
+	// batch since the batch is logged as a summary.
+	Done func(err error, logProcessed logFunc)
+}
+
+// logFunc is the signature of the logging helpers.
+type logFunc func(msg string, keyvals ...interface{})
+
+// updateBatch collects the jobs of webhooks sharing a request ID.
+type updateBatch struct {
+	jobs    []*updateJob
+	planned scheduledChanges
+	timer   *time.Timer
+}
+
+// UpdateBatcher groups the DNS changes of bulk operations in NetBox, which
+// arrive as a burst of webhooks sharing a request ID, into batched updates.
+type UpdateBatcher struct {
+	mu      sync.Mutex
+	batches map[string]*updateBatch
+}
+
+// updateBatcher is the global batcher of DNS changes.
+var updateBatcher = &UpdateBatcher{batches: make(map[string]*updateBatch)}
+
+// Submit queues a job. Jobs without a request ID, or all jobs when batching is
+// disabled, run right away. The first job of a request ID also runs right away,
+// so that the change of a single record is never delayed, and opens a batch for
+// the jobs of the same request ID that follow. The batch is run once no further
+// job arrived within the batch window or the batch is full.
+func (b *UpdateBatcher) Submit(requestID string, job *updateJob, config *Config, lockManager *RecordLockManager) {
+	if requestID == "" || config.BatchWindowMS <= 0 {
+		go runUpdateJobs("", []*updateJob{job}, config, lockManager)
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	batch, exists := b.batches[requestID]
+	if !exists {
+		batch = &updateBatch{}
+		batch.timer = time.AfterFunc(time.Duration(config.BatchWindowMS)*time.Millisecond, func() {
+			b.flush(requestID, batch, config, lockManager)
+		})
+		b.batches[requestID] = batch
+		batch.planned.add(job.Planned)
+		go runUpdateJobs(requestID, []*updateJob{job}, config, lockManager)
+		return
+	}
+	batch.timer.Reset(time.Duration(config.BatchWindowMS) * time.Millisecond)
+	batch.jobs = append(batch.jobs, job)
+	batch.planned.add(job.Planned)
+
+	if config.BatchMaxRecords > 0 && len(batch.jobs) >= config.BatchMaxRecords {
+		batch.timer.Stop()
+		delete(b.batches, requestID)
+		go runUpdateJobs(requestID, batch.jobs, config, lockManager)
+	}
+}
+
+// Scheduled returns the planned changes of the jobs queued for a request ID
+// whose batch is still open, in order. Some of them may already be sent.
+func (b *UpdateBatcher) Scheduled(requestID string) *scheduledChanges {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	scheduled := &scheduledChanges{}
+	if batch, exists := b.batches[requestID]; exists && requestID != "" {
+		scheduled.changes = append(scheduled.changes, batch.planned.changes...)
+	}
+	return scheduled
+}
+
+// flush runs the batch of a request ID unless it was already run because it
+// was full, or no job followed the first one.
+func (b *UpdateBatcher) flush(requestID string, batch *updateBatch, config *Config, lockManager *RecordLockManager) {
+	b.mu.Lock()
+	if b.batches[requestID] != batch {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.batches, requestID)
+	b.mu.Unlock()
+
+	if len(batch.jobs) == 0 {
+		return
+	}
+
+	runUpdateJobs(requestID, batch.jobs, config, lockManager)
+}
+
+// runUpdateJobs executes jobs in order while holding the locks of all of them.
+// A single job runs as its own nsupdate script. Several jobs are merged into
+// as few UPDATE messages as possible and logged as one summary; if the merged
+// update fails, each job is retried on its own so that one rejected record
+// does not fail the rest. Re-sending already applied changes is harmless, as
+// adding an existing record and deleting a missing one are no-ops.
+func runUpdateJobs(requestID string, jobs []*updateJob, config *Config, lockManager *RecordLockManager) {
+	var locks []string
+	for _, job := range jobs {
+		locks = append(locks, job.Locks...)
+	}
+	lockManager.AcquireLocks(locks...)
+	defer lockManager.ReleaseLocks(locks...)
+
+	// Build the scripts; jobs failing their check and jobs without changes are dropped
+	var pending []*updateJob
+	var scripts []string
+	scheduled := &scheduledChanges{}
+	for _, job := range jobs {
+		if job.Check != nil {
+			if err := job.Check(scheduled); err != nil {
+				job.Done(err, logInfo)
+				continue
+			}
+		}
+		if script := job.Script(); script != "" {
+			pending = append(pending, job)
+			scripts = append(scripts, script)
+			scheduled.add(script)
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	if len(pending) == 1 {
+		pending[0].Done(ExecuteNSUpdate(scripts[0], config), logInfo)
+		return
+	}
+
+	start := time.Now()
+	script, messages := mergeNSUpdateScripts(pending, scripts)
+
+	logDebug("Outgoing nsupdate script for batch", "request_id", requestID, "script", script)
+
+	failed := 0
+	retried := false
+	if err := ExecuteNSUpdate(script, config); err != nil {
+		logWarn("Batched nsupdate failed, retrying records individually",
+			"request_id", requestID,
+			"records", len(pending),
+			"err", err,
+		)
+		retried = true
+		for i, job := range pending {
+			err := ExecuteNSUpdate(scripts[i], config)
+			if err != nil {
+				failed++
+			}
+			job.Done(err, logDebug)
+		}
+	} else {
+		for _, job := range pending {
+			job.Done(nil, logDebug)
+		}
+	}
+
+	logInfo("Processed batched DNS update",
+		"request_id", requestID,
+		"records", len(pending),
+		"messages", messages,
+		"failed", failed,
+		"retried", retried,
+		"duration", time.Since(start),
+	)
+}
+
+// updateMessage is one UPDATE message of an nsupdate script.
+type updateMessage struct {
+	server string
+	zone   string
+	lines  []string
+	prereq bool
+}
+
+// parseNSUpdateScript splits an nsupdate script into its UPDATE messages.
+func parseNSUpdateScript(script string) []updateMessage {
+	var messages []updateMessage
+	var current updateMessage
+	for _, line := range strings.Split(script, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "server "):
+			current.server = line
+		case strings.HasPrefix(line, "zone "):
+			current.zone = strings.TrimSpace(strings.TrimPrefix(line, "zone "))
+		case line == "send":
+			if len(current.lines) > 0 {
+				messages = append(messages, current)
+			}
+			current = updateMessage{server: current.server}
+		default:
+			if strings.HasPrefix(line, "prereq ") {
+				current.prereq = true
+			}
+			current.lines = append(current.lines, line)
+		}
+	}
+	return messages
+}
+
+// mergeNSUpdateScripts merges the scripts of several jobs into a single nsupdate
+// script. Updates to the same zone on the same server are combined into shared
+// messages in their original order; messages of jobs without a known zone and
+// messages with prerequisites are kept as they are. Messages are only merged
+// across other mergeable messages, never across a message kept as it is, so
+// that no update is sent before one that precedes it. It returns the script
+// and the number of UPDATE messages it sends.
+func mergeNSUpdateScripts(jobs []*updateJob, scripts []string) (string, int) {
+	type mergeKey struct{ server, zone string }
+
+	var merged []*updateMessage
+	open := make(map[mergeKey]*updateMessage)
+	for i, job := range jobs {
+		for _, message := range parseNSUpdateScript(scripts[i]) {
+			if message.zone == "" {
+				message.zone = canonicalName(job.Zone)
+			}
+			if message.zone == "." || message.zone == "" || message.prereq {
+				m := message
+				merged = append(merged, &m)
+				open = make(map[mergeKey]*updateMessage)
+				continue
+			}
+			key := mergeKey{message.server, message.zone}
+			target, exists := open[key]
+			if !exists || len(target.lines)+len(message.lines) > maxUpdatesPerMessage {
+				target = &updateMessage{server: message.server, zone: message.zone}
+				open[key] = target
+				merged = append(merged, target)
+			}
+			target.lines = append(target.lines, message.lines...)
+		}
+	}
+
+	var script strings.Builder
+	for _, message := range merged {
+		script.WriteString(message.server + "\n")
+		if message.zone != "" && message.zone != "." {
+			script.WriteString("zone " + message.zone + "\n")
+		}
+		for _, line := range message.lines {
+			script.WriteString(line + "\n")
+		}
+		script.WriteString("send\n")
+	}
+	return script.String(), len(merged)
+}
+
+// scheduledChange is a record added or removed by a job of a batch. A removal
+// without value removes the RRset, a removal without type all RRsets at the name.
+type scheduledChange struct {
+	add    bool
+	record ResourceRecord
+}
+
+// scheduledChanges are the record changes of the jobs of a batch that run
+// before the current job, in order.
+type scheduledChanges struct {
+	changes []scheduledChange
+}
+
+// add records the updates of an nsupdate script.
+func (s *scheduledChanges) add(script string) {
+	for _, message := range parseNSUpdateScript(script) {
+		for _, line := range message.lines {
+			fields := strings.Fields(line)
+			switch {
+			case len(fields) >= 7 && fields[0] == "update" && fields[1] == "add":
+				// update add <name> <ttl> IN <type> <value>
+				s.changes = append(s.changes, scheduledChange{add: true, record: ResourceRecord{
+					Name:  fields[2],
+					Type:  strings.ToUpper(fields[5]),
+					Value: strings.Join(fields[6:], " "),
+				}})
+			case len(fields) >= 3 && fields[0] == "update" && fields[1] == "delete":
+				// update delete <name> [<type> [<value>]]
+				change := scheduledChange{record: ResourceRecord{Name: fields[2]}}
+				if len(fields) >= 4 {
+					change.record.Type = strings.ToUpper(fields[3])
+				}
+				if len(fields) >= 5 {
+					change.record.Value = strings.Join(fields[4:], " ")
+				}
+				s.changes = append(s.changes, change)
+			}
+		}
+	}
+}
+
+// apply returns the records at fqdn once the scheduled changes are applied to existing.
+func (s *scheduledChanges) apply(fqdn string, existing []ResourceRecord) []ResourceRecord {
+	records := append([]ResourceRecord(nil), existing...)
+	for _, change := range s.changes {
+		if !sameName(change.record.Name, fqdn) {
+			continue
+		}
+		if change.add {
+			records = append(records, change.record)
+			continue
+		}
+		var kept []ResourceRecord
+		for _, rr := range records {
+			removed := (change.record.Type == "" || rr.Type == change.record.Type) &&
+				(change.record.Value == "" || sameRecordValue(rr.Value, change.record.Value))
+			if !removed {
+				kept = append(kept, rr)
+			}
+		}
+		records = kept
+	}
+	return records
+}
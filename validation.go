@@ -0,0 +1,152 @@
+// validation.go
+
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// maxTTL is the largest TTL allowed by RFC 2181 section 8.
+const maxTTL = 2147483647
+
+// knownRecordTypes are the record types accepted in payloads.
+var knownRecordTypes = map[string]bool{
+	"A": true, "AAAA": true, "AFSDB": true, "CAA": true, "CERT": true, "CNAME": true,
+	"DNAME": true, "DNSKEY": true, "DS": true, "HINFO": true, "HTTPS": true, "LOC": true,
+	"MX": true, "NAPTR": true, "NS": true, "PTR": true, "RP": true, "SOA": true,
+	"SPF": true, "SRV": true, "SSHFP": true, "SVCB": true, "TLSA": true, "TXT": true, "URI": true,
+}
+
+// FieldError describes a validation error of a single payload field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors collects the field errors of a payload.
+type ValidationErrors []FieldError
+
+// Error implements the error interface.
+func (e ValidationErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, fieldError := range e {
+		messages = append(messages, fieldError.Field+": "+fieldError.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// add appends a field error.
+func (e *ValidationErrors) add(field, format string, args ...interface{}) {
+	*e = append(*e, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// validateRecord checks the fields of a record or snapshot.
+func (e *ValidationErrors) validateRecord(prefix, fqdn, recordType, value string, ttl *int) {
+	if fqdn == "" {
+		e.add(prefix+".fqdn", "is required")
+	} else if err := validateDomainName(fqdn); err != nil {
+		e.add(prefix+".fqdn", "%v", err)
+	}
+
+	recordType = strings.ToUpper(recordType)
+	if recordType == "" {
+		e.add(prefix+".type", "is required")
+	} else if !knownRecordTypes[recordType] {
+		e.add(prefix+".type", "unknown record type %q", recordType)
+	}
+
+	if strings.TrimSpace(value) == "" {
+		e.add(prefix+".value", "is required")
+	} else if err := validateRecordValue(recordType, value); err != nil {
+		e.add(prefix+".value", "%v", err)
+	}
+
+	if ttl != nil && (*ttl < 0 || *ttl > maxTTL) {
+		e.add(prefix+".ttl", "must be between 0 and %d", maxTTL)
+	}
+}
+
+// validateRecordValue checks the value of a record against its type.
+func validateRecordValue(recordType, value string) error {
+	value = strings.TrimSpace(value)
+	fields := strings.Fields(value)
+
+	switch recordType {
+	case "A":
+		addr, err := netip.ParseAddr(value)
+		if err != nil || !addr.Is4() {
+			return fmt.Errorf("%q is not an IPv4 address", value)
+		}
+	case "AAAA":
+		addr, err := netip.ParseAddr(value)
+		if err != nil || !addr.Is6() {
+			return fmt.Errorf("%q is not an IPv6 address", value)
+		}
+	case "CNAME", "DNAME", "NS", "PTR":
+		return validateDomainName(value)
+	case "MX":
+		if len(fields) != 2 {
+			return fmt.Errorf("%q is not of the form <preference> <exchange>", value)
+		}
+		if err := validateUint16(fields[0]); err != nil {
+			return fmt.Errorf("preference %v", err)
+		}
+		return validateDomainName(fields[1])
+	case "SRV":
+		if len(fields) != 4 {
+			return fmt.Errorf("%q is not of the form <priority> <weight> <port> <target>", value)
+		}
+		for _, field := range fields[:3] {
+			if err := validateUint16(field); err != nil {
+				return fmt.Errorf("priority, weight and port %v", err)
+			}
+		}
+		return validateDomainName(fields[3])
+	}
+	return nil
+}
+
+// validateDomainName checks the syntax of a domain name after conversion to its ASCII form.
+// Underscore labels, RFC 2317 labels and a leading wildcard label are accepted.
+func validateDomainName(name string) error {
+	ascii, err := toASCIIName(strings.TrimSpace(name))
+	if err != nil {
+		return err
+	}
+	if ascii == "." {
+		return nil
+	}
+
+	ascii = strings.TrimSuffix(ascii, ".")
+	if len(ascii) > 253 {
+		return fmt.Errorf("name %q is longer than 253 characters", name)
+	}
+	for i, label := range strings.Split(ascii, ".") {
+		if label == "" {
+			return fmt.Errorf("name %q contains an empty label", name)
+		}
+		if len(label) > 63 {
+			return fmt.Errorf("label %q is longer than 63 characters", label)
+		}
+		if label == "*" && i == 0 {
+			continue
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '/') {
+				return fmt.Errorf("label %q contains invalid character %q", label, c)
+			}
+		}
+	}
+	return nil
+}
+
+// validateUint16 checks that s is a number between 0 and 65535.
+func validateUint16(s string) error {
+	if _, err := strconv.ParseUint(s, 10, 16); err != nil {
+		return fmt.Errorf("must be a number between 0 and 65535")
+	}
+	return nil
+}
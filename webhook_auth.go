@@ -0,0 +1,55 @@
+// webhook_auth.go
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// verifyWebhookSignature checks the X-Hook-Signature header (HMAC-SHA512 of the body)
+// against the configured webhook secrets. During a rotation window the previous
+// secret is accepted as well. It returns the HTTP status to reject the request
+// with and the reason, or 0 and nil if the request is authentic.
+func verifyWebhookSignature(r *http.Request, body []byte, config *Config) (int, error) {
+	if config.WebhookSecret == "" {
+		return 0, nil
+	}
+
+	signature := strings.TrimSpace(r.Header.Get("X-Hook-Signature"))
+	if signature == "" {
+		return http.StatusUnauthorized, errors.New("missing X-Hook-Signature header")
+	}
+	provided, err := hex.DecodeString(signature)
+	if err != nil {
+		return http.StatusForbidden, errors.New("malformed X-Hook-Signature header")
+	}
+
+	if hmac.Equal(provided, computeSignature(config.WebhookSecret, body)) {
+		return 0, nil
+	}
+
+	if config.WebhookSecretPrevious != "" && hmac.Equal(provided, computeSignature(config.WebhookSecretPrevious, body)) {
+		if !config.webhookSecretPreviousExpires.IsZero() && time.Now().After(config.webhookSecretPreviousExpires) {
+			return http.StatusForbidden, errors.New("signature uses an expired webhook secret")
+		}
+		logWarn("Webhook signed with previous secret, update the secret in NetBox",
+			"remote_addr", r.RemoteAddr,
+		)
+		return 0, nil
+	}
+
+	return http.StatusForbidden, errors.New("invalid X-Hook-Signature")
+}
+
+// computeSignature returns the HMAC-SHA512 of body using secret.
+func computeSignature(secret string, body []byte) []byte {
+	mac := hmac.New(sha512.New, []byte(secret))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
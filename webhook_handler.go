@@ -3,59 +3,172 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"strings"
 )
 
 // webhookHandler handles incoming webhook POST requests.
 func webhookHandler(w http.ResponseWriter, r *http.Request, config *Config, lockManager *RecordLockManager) {
+	sourceWebhookHandler(w, r, netBoxAdapter{}, config, lockManager)
+}
+
+// profileWebhookHandler handles webhooks with a custom body, mapped to the
+// webhook payload by the payload profile.
+func profileWebhookHandler(w http.ResponseWriter, r *http.Request, profile *PayloadProfile, config *Config, lockManager *RecordLockManager) {
+	serveWebhook(w, r, config, func(w http.ResponseWriter, body []byte) {
+		mapped, err := profile.Map(body)
+		if err != nil {
+			logError("Failed to map webhook payload", "profile", profile.Name, "err", err)
+			writeProblemDetails(w, Problem{
+				Status: http.StatusUnprocessableEntity,
+				Title:  "Payload mapping failed",
+				Detail: err.Error(),
+			})
+			return
+		}
+		logDebug("Mapped webhook payload", "profile", profile.Name, "payload", string(mapped))
+
+		handleSourcePayload(w, r, netBoxAdapter{}, mapped, config, lockManager)
+	})
+}
+
+// serveWebhook reads and authenticates a webhook request and passes the body to process.
+func serveWebhook(w http.ResponseWriter, r *http.Request, config *Config, process func(w http.ResponseWriter, body []byte)) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		w.Header().Set("Allow", http.MethodPost)
+		writeProblem(w, http.StatusMethodNotAllowed, "Webhooks must be sent with POST")
+		return
+	}
+
+	// Only JSON payloads are accepted
+	if !isJSONContentType(r.Header.Get("Content-Type")) {
+		logWarn("Rejected webhook with unsupported content type",
+			"content_type", r.Header.Get("Content-Type"),
+			"remote_addr", r.RemoteAddr,
+		)
+		w.Header().Set("Accept-Post", "application/json")
+		writeProblem(w, http.StatusUnsupportedMediaType, "Webhook payloads must be sent as application/json")
 		return
 	}
 
-	// Read the request body
+	// Read the request body, up to the configured size limit
+	if config.MaxBodyBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, config.MaxBodyBytes)
+	}
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			logWarn("Rejected webhook exceeding the body size limit",
+				"limit", tooLarge.Limit,
+				"remote_addr", r.RemoteAddr,
+			)
+			writeProblem(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Webhook payloads are limited to %d bytes", tooLarge.Limit))
+			return
+		}
 		logError("Error reading request body", "err", err)
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		writeProblem(w, http.StatusBadRequest, "Failed to read request body")
 		return
 	}
 	defer r.Body.Close()
 
+	// Verify the webhook signature before looking at the payload
+	if status, err := verifyWebhookSignature(r, body, config); err != nil {
+		logWarn("Rejected webhook with invalid signature",
+			"err", err,
+			"remote_addr", r.RemoteAddr,
+			"client_subject", clientSubject(r),
+		)
+		writeProblem(w, status, "Missing or invalid webhook signature")
+		return
+	}
+
 	// Log the incoming JSON payload if log level is DEBUG
-	logDebug("Received webhook payload", "payload", string(body))
+	logDebug("Received webhook payload", "payload", string(body), "client_subject", clientSubject(r))
 
-	// Parse the JSON payload
-	var payload WebhookPayload
-	if err := json.Unmarshal(body, &payload); err != nil {
-		logError("Error parsing JSON", "err", err)
-		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
-		return
+	process(w, body)
+}
+
+// objectResult is the result of one object of a payload carrying several objects.
+type objectResult struct {
+	Index   int             `json:"index"`
+	Status  int             `json:"status"`
+	Message string          `json:"message,omitempty"`
+	Body    json.RawMessage `json:"body,omitempty"`
+}
+
+// objectResponseRecorder captures the response for one object of a payload carrying several objects.
+type objectResponseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *objectResponseRecorder) Header() http.Header         { return rec.header }
+func (rec *objectResponseRecorder) Write(b []byte) (int, error) { return rec.body.Write(b) }
+func (rec *objectResponseRecorder) WriteHeader(status int)      { rec.status = status }
+
+// respondPerObject handles count objects, each against its own recorded
+// response, and responds with the result per object: 200 OK if all objects
+// were accepted and 207 Multi-Status otherwise. It returns the number of
+// rejected objects.
+func respondPerObject(w http.ResponseWriter, count int, handle func(i int, w http.ResponseWriter)) int {
+	status := http.StatusOK
+	rejected := 0
+	results := make([]objectResult, 0, count)
+	for i := 0; i < count; i++ {
+		rec := &objectResponseRecorder{header: make(http.Header), status: http.StatusOK}
+		handle(i, rec)
+
+		result := objectResult{Index: i, Status: rec.status}
+		if isJSONContentType(rec.header.Get("Content-Type")) {
+			result.Body = json.RawMessage(bytes.TrimSpace(rec.body.Bytes()))
+		} else {
+			result.Message = strings.TrimSpace(rec.body.String())
+		}
+		if rec.status >= http.StatusMultipleChoices {
+			status = http.StatusMultiStatus
+			rejected++
+		}
+		results = append(results, result)
 	}
 
-	// Validate the payload
-	if err := payload.Validate(); err != nil {
-		logError("Payload validation error", "err", err)
-		http.Error(w, "Invalid payload data", http.StatusBadRequest)
-		return
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"results": results,
+	})
+	return rejected
+}
+
+// writeValidationErrors responds with 422 and a machine-readable list of field errors.
+func writeValidationErrors(w http.ResponseWriter, err error) {
+	fieldErrors, ok := err.(ValidationErrors)
+	if !ok {
+		fieldErrors = ValidationErrors{{Field: "", Message: err.Error()}}
 	}
 
-	// Determine the event type
-	eventType := strings.ToLower(payload.Event)
-	switch eventType {
-	case "created":
-		handleCreatedEvent(w, config, lockManager, &payload)
-	case "deleted":
-		handleDeletedEvent(w, config, lockManager, &payload)
-	case "updated":
-		handleUpdatedEvent(w, config, lockManager, &payload)
-	default:
-		logError("Unsupported event type", "event", payload.Event)
-		http.Error(w, "Unsupported event type", http.StatusBadRequest)
+	writeProblemDetails(w, Problem{
+		Status: http.StatusUnprocessableEntity,
+		Title:  "Invalid payload data",
+		Detail: fmt.Sprintf("The payload has %d invalid field(s)", len(fieldErrors)),
+		Errors: fieldErrors,
+	})
+}
+
+// isJSONContentType reports whether contentType is application/json or a +json media type.
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
 	}
+	return mediaType == "application/json" || (strings.HasPrefix(mediaType, "application/") && strings.HasSuffix(mediaType, "+json"))
 }
 
 // healthzHandler responds with "OK" for health checks.
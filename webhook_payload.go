@@ -2,9 +2,27 @@
 
 package main
 
+import "strings"
+
+// Models the service receives webhooks for.
+const (
+	ModelRecord = "netbox_dns.record"
+	ModelZone   = "netbox_dns.zone"
+)
+
+// Payload formats of the NetBox versions the service understands.
+const (
+	PayloadFormatNetBox3 = "netbox3" // "model": "record"
+	PayloadFormatNetBox4 = "netbox4" // "object_type": "netbox_dns.record"
+)
+
 // WebhookPayload represents the structure of the webhook payload.
+// NetBox 3.x identifies the model with "model", NetBox 4.x with "object_type".
 type WebhookPayload struct {
-	Event     string         `json:"event"`
+	Event      string `json:"event"`
+	Model      string `json:"model"`
+	ObjectType string `json:"object_type"`
+
 	Username  string         `json:"username"`
 	RequestID string         `json:"request_id"`
 	Timestamp string         `json:"timestamp"`
@@ -21,7 +39,10 @@ type RecordData struct {
 	Value      string   `json:"value"`
 	TTL        *int     `json:"ttl"`
 	DisablePTR bool     `json:"disable_ptr"`
+	Managed    bool     `json:"managed"` // Set for records created by NetBox DNS itself
 	Zone       ZoneData `json:"zone"`
+
+	CustomFields map[string]interface{} `json:"custom_fields"`
 }
 
 // Snapshot represents the state of a DNS record before or after a change.
@@ -33,7 +54,10 @@ type Snapshot struct {
 	Value      string `json:"value"`
 	TTL        *int   `json:"ttl"`
 	DisablePTR bool   `json:"disable_ptr"`
+	Managed    bool   `json:"managed"`
 	Zone       int    `json:"zone"` // Zone is an integer (ID)
+
+	CustomFields map[string]interface{} `json:"custom_fields"`
 }
 
 // ZoneData represents DNS zone information.
@@ -48,8 +72,100 @@ type SnapshotsData struct {
 	PostChange *Snapshot `json:"postchange"`
 }
 
+// Format returns the NetBox payload format, or an empty string if the payload names no model.
+func (wp *WebhookPayload) Format() string {
+	switch {
+	case wp.ObjectType != "":
+		return PayloadFormatNetBox4
+	case wp.Model != "":
+		return PayloadFormatNetBox3
+	}
+	return ""
+}
+
+// ModelName returns the normalized model the webhook was sent for, e.g. ModelRecord.
+// Payloads without a model are assumed to describe records.
+func (wp *WebhookPayload) ModelName() string {
+	model := strings.ToLower(wp.ObjectType)
+	if model == "" {
+		model = strings.ToLower(wp.Model)
+	}
+	if model == "" {
+		return ModelRecord
+	}
+
+	// Split "app_label.model"; NetBox 3.x only sends the model name
+	appLabel, name := "netbox_dns", model
+	if i := strings.LastIndex(model, "."); i >= 0 {
+		appLabel, name = model[:i], model[i+1:]
+	}
+	if appLabel != "netbox_dns" && appLabel != "dns" {
+		return model
+	}
+	switch name {
+	case "record":
+		return ModelRecord
+	case "zone":
+		return ModelZone
+	}
+	return model
+}
+
+// ObjectID returns the ID of the object the webhook was sent for, falling back
+// to the snapshots for payloads whose data carries no ID.
+func (wp *WebhookPayload) ObjectID() int {
+	if wp.Data.ID != 0 || wp.Snapshots == nil {
+		return wp.Data.ID
+	}
+	if wp.Snapshots.PostChange != nil && wp.Snapshots.PostChange.ID != 0 {
+		return wp.Snapshots.PostChange.ID
+	}
+	if wp.Snapshots.PreChange != nil {
+		return wp.Snapshots.PreChange.ID
+	}
+	return 0
+}
+
+// normalizeEvent maps NetBox 4.x event type names ("object_created") to the
+// event names of the webhook payload ("created").
+func normalizeEvent(event string) string {
+	return strings.TrimPrefix(strings.ToLower(strings.TrimSpace(event)), "object_")
+}
+
 // Validate ensures that the webhook payload contains the necessary data.
+// It returns ValidationErrors listing every invalid field.
 func (wp *WebhookPayload) Validate() error {
-	// Add validation logic as needed.
+	var errs ValidationErrors
+
+	switch strings.ToLower(wp.Event) {
+	case "":
+		errs.add("event", "is required")
+	case "created":
+		errs.validateRecord("data", wp.Data.FQDN, wp.Data.Type, wp.Data.Value, wp.Data.TTL)
+	case "deleted":
+		if wp.Snapshots == nil || wp.Snapshots.PreChange == nil {
+			errs.add("snapshots.prechange", "is required for deleted events")
+		} else {
+			pre := wp.Snapshots.PreChange
+			errs.validateRecord("snapshots.prechange", pre.FQDN, pre.Type, pre.Value, pre.TTL)
+		}
+	case "updated":
+		if wp.Snapshots == nil || wp.Snapshots.PostChange == nil {
+			errs.add("snapshots.postchange", "is required for updated events")
+		} else {
+			post := wp.Snapshots.PostChange
+			errs.validateRecord("snapshots.postchange", post.FQDN, post.Type, post.Value, post.TTL)
+		}
+		if wp.Snapshots != nil && wp.Snapshots.PreChange != nil {
+			pre := wp.Snapshots.PreChange
+			errs.validateRecord("snapshots.prechange", pre.FQDN, pre.Type, pre.Value, pre.TTL)
+		}
+	default:
+		errs.add("event", "unsupported event type %q", wp.Event)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
 	return nil
 }
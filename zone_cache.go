@@ -0,0 +1,49 @@
+// zone_cache.go
+
+package main
+
+import "sync"
+
+// ZoneCache caches the NetBox DNS zones seen in zone webhooks.
+// It supplies zone names, default TTLs and SOA settings to record handling.
+type ZoneCache struct {
+	mu    sync.Mutex
+	zones map[int]ZoneObject
+}
+
+// zoneCache holds the zones seen by this service.
+var zoneCache = &ZoneCache{}
+
+// Set stores or refreshes a zone.
+func (c *ZoneCache) Set(zone ZoneObject) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.zones == nil {
+		c.zones = make(map[int]ZoneObject)
+	}
+	c.zones[zone.ID] = zone
+}
+
+// Delete forgets a zone.
+func (c *ZoneCache) Delete(zoneID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.zones, zoneID)
+}
+
+// Get returns a cached zone.
+func (c *ZoneCache) Get(zoneID int) (ZoneObject, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	zone, exists := c.zones[zoneID]
+	return zone, exists
+}
+
+// DefaultTTL returns the default TTL of a cached zone, or fallback if it is unknown.
+func (c *ZoneCache) DefaultTTL(zoneID int, fallback int) int {
+	zone, exists := c.Get(zoneID)
+	if !exists || zone.DefaultTTL == nil || *zone.DefaultTTL <= 0 {
+		return fallback
+	}
+	return *zone.DefaultTTL
+}
@@ -0,0 +1,241 @@
+// zone_handlers.go
+
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Zone purge modes for deleted zones.
+const (
+	// ZonePurgeOff leaves the records of deleted zones on the DNS server.
+	ZonePurgeOff = "off"
+	// ZonePurgeDryRun logs the records that would be removed without removing them.
+	ZonePurgeDryRun = "dry-run"
+	// ZonePurgeOn removes the records the service manages in deleted zones.
+	ZonePurgeOn = "purge"
+)
+
+// handleZoneEvent processes webhook events for NetBox DNS zones.
+// Each event is processed as a single job and logged with one summary entry.
+// The delivery identified by deliveryKey is released if the job fails.
+func handleZoneEvent(w http.ResponseWriter, payload *ZonePayload, deliveryKey string, config *Config, lockManager *RecordLockManager) {
+	jobID := newJobID()
+	zone := payload.Zone()
+
+	go func() {
+		// Serialize jobs per zone
+		lockKey := "zone:" + canonicalName(zone.Name)
+		lockManager.AcquireLock(lockKey)
+		defer lockManager.ReleaseLock(lockKey)
+
+		summary := []interface{}{
+			"job_id", jobID,
+			"event", payload.Event,
+			"zone", zone.Name,
+			"zone_id", zone.ID,
+			"user", payload.Username,
+			"request_id", payload.RequestID,
+		}
+		fail := func(err error) {
+			logError("Failed to process zone event", append(summary, "err", err)...)
+			releaseDelivery(deliveryKey)
+		}
+
+		// Remember the previous settings to detect renames and view changes.
+		// The pre-change snapshot takes precedence, as the cache is empty after a restart.
+		previous, known := zoneCache.Get(zone.ID)
+		previousName := payload.PreviousName()
+		if previousName == "" && known {
+			previousName = previous.Name
+		}
+		previousView, viewKnown := payload.PreviousView()
+		if !viewKnown && known {
+			previousView, viewKnown = previous.View, true
+		}
+		if previousView.Name == "" {
+			// Snapshots only carry the view ID; take the name from the zone if it is the same view
+			if known && sameView(previous.View, previousView) {
+				previousView.Name = previous.View.Name
+			} else if sameView(zone.View, previousView) {
+				previousView.Name = zone.View.Name
+			}
+		}
+
+		switch payload.Event {
+		case "created":
+			zoneCache.Set(zone)
+			summary = append(summary, "default_ttl", ttlValue(zone.DefaultTTL))
+
+			// Provision the zone on the servers through its catalog zone
+			catalog, err := updateCatalogMembership("created", zone.Name, zone.View.Name, config)
+			summary = append(summary, "catalog", catalog)
+			if err != nil {
+				fail(err)
+				return
+			}
+		case "updated":
+			// Refresh the cached zone settings
+			zoneCache.Set(zone)
+			summary = append(summary, "default_ttl", ttlValue(zone.DefaultTTL))
+			renamed := previousName != "" && !sameName(previousName, zone.Name)
+			if renamed {
+				summary = append(summary, "renamed_from", previousName)
+			}
+
+			// Push SOA and apex NS changes; renamed zones are provisioned from scratch
+			if config.ZoneApexSync && !renamed {
+				changes, err := syncZoneApex(zone, config)
+				summary = append(summary, "apex_changes", strings.Join(changes, ","))
+				if err != nil {
+					fail(err)
+					return
+				}
+			}
+
+			// Move the catalog membership if the zone was renamed or moved to another view
+			viewChanged := viewKnown && !sameView(previousView, zone.View)
+			if renamed || viewChanged {
+				oldView := zone.View
+				if viewChanged {
+					oldView = previousView
+					summary = append(summary, "moved_from_view", previousView.ID)
+				}
+				oldName := zone.Name
+				if renamed {
+					oldName = previousName
+				}
+				if _, err := removeCatalogMembership(oldName, oldView, zone.View.Name, config); err != nil {
+					fail(err)
+					return
+				}
+				catalog, err := updateCatalogMembership("created", zone.Name, zone.View.Name, config)
+				summary = append(summary, "catalog", catalog)
+				if err != nil {
+					fail(err)
+					return
+				}
+			}
+		case "deleted":
+			zoneCache.Delete(zone.ID)
+			purged, err := purgeZoneRecords(zone, config, lockManager)
+			summary = append(summary, "purge", config.ZoneDeletePurge, "records", purged)
+			if err != nil {
+				fail(err)
+				return
+			}
+
+			// Remove the zone from the servers through its catalog zone
+			view := zone.View
+			if view.Name == "" && view.ID == 0 && viewKnown {
+				view = previousView
+			}
+			catalog, err := removeCatalogMembership(zone.Name, view, "", config)
+			summary = append(summary, "catalog", catalog)
+			if err != nil {
+				fail(err)
+				return
+			}
+		}
+
+		logInfo("Processed zone event", summary...)
+	}()
+
+	// Respond immediately
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Webhook received and is being processed"))
+}
+
+// sameView reports whether two views are the same, comparing IDs where both are known.
+func sameView(a, b NamedObject) bool {
+	if a.ID != 0 && b.ID != 0 {
+		return a.ID == b.ID
+	}
+	return a.Name == b.Name
+}
+
+// purgeZoneRecords removes the records the service manages in a deleted zone,
+// including the PTR records generated for them, in a single nsupdate run.
+// It returns the number of records removed, or that would be removed in dry-run mode.
+func purgeZoneRecords(zone ZoneObject, config *Config, lockManager *RecordLockManager) (int, error) {
+	if config.ZoneDeletePurge != ZonePurgeOn && config.ZoneDeletePurge != ZonePurgeDryRun {
+		return 0, nil
+	}
+
+	records := recordIndex.InZone(zone.ID)
+	if len(records) == 0 {
+		return 0, nil
+	}
+
+	if config.ZoneDeletePurge == ZonePurgeDryRun {
+		for _, record := range records {
+			logInfo("Would remove record of deleted zone",
+				"zone", zone.Name,
+				"fqdn", record.FQDN,
+				"record_type", record.Type,
+				"value", record.Value,
+				"record_id", record.RecordID,
+			)
+		}
+		return len(records), nil
+	}
+
+	// Lock the PTR owner names the records may release along with the records
+	var lockKeys []string
+	var recordIDs []int
+	ptrCandidates := make(map[int][]string)
+	for _, record := range records {
+		lockKeys = append(lockKeys, record.FQDN)
+		recordIDs = append(recordIDs, record.RecordID)
+		if !record.PTR || config.PTRSource != PTRSourceService {
+			continue
+		}
+		if ip := ptrReleaseAddress(record.Value, config); ip != "" {
+			owner, _ := ptrIndex.Owner(record.RecordID)
+			ptrCandidates[record.RecordID] = []string{owner, ptrOwnerName(ip, config)}
+			lockKeys = append(lockKeys, ptrCandidates[record.RecordID]...)
+		}
+	}
+	lockManager.AcquireLocks(lockKeys...)
+	defer lockManager.ReleaseLocks(lockKeys...)
+
+	host := extractHost(config.BindServerAddress)
+	port := extractPort(config.BindServerAddress)
+
+	var script strings.Builder
+	var undos []*ptrUndo
+	for _, record := range records {
+		script.WriteString(ConstructNSUpdateScript(host, port, record.FQDN, record.Type, record.Value, "", "deleted", 0))
+
+		// Release the PTR claim of the record
+		candidates, exists := ptrCandidates[record.RecordID]
+		if !exists {
+			continue
+		}
+		ptrName := ptrReleaseOwner(&RecordData{ID: record.RecordID}, candidates...)
+		changes, undo := ptrIndex.Apply(&ptrRelease{Owner: ptrName, RecordID: record.RecordID, FQDN: record.FQDN}, nil, config.PTRPrimaryPolicy)
+		undos = append(undos, undo)
+		for _, change := range changes {
+			if _, exists := explicitPTRs.Explicit(change.Owner); exists {
+				continue
+			}
+			script.WriteString(ConstructPTRChangeScript(host, port, change.Owner, change.Remove, change.Add, ptrTTL(change.Owner, change.TTL, config)))
+		}
+	}
+
+	logDebug("Outgoing nsupdate script for zone purge", "zone", zone.Name, "script", script.String())
+
+	if err := ExecuteNSUpdate(script.String(), config); err != nil {
+		// Restore the released PTR claims, newest first
+		for i := len(undos) - 1; i >= 0; i-- {
+			settlePTRClaims(err, undos[i], config)
+		}
+		return 0, err
+	}
+	settlePTRClaims(nil, nil, config)
+	if err := recordIndex.Remove(recordIDs...); err != nil {
+		logError("Failed to persist record index", "err", err, "file", config.RecordIndexFile)
+	}
+	return len(records), nil
+}
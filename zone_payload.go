@@ -0,0 +1,163 @@
+// zone_payload.go
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ZonePayload represents the webhook payload for NetBox DNS zones.
+type ZonePayload struct {
+	Event     string         `json:"event"`
+	Username  string         `json:"username"`
+	RequestID string         `json:"request_id"`
+	Timestamp string         `json:"timestamp"`
+	Data      ZoneObject     `json:"data"`
+	Snapshots *ZoneSnapshots `json:"snapshots"`
+}
+
+// ZoneObject represents a NetBox DNS zone in the data or snapshots of a zone payload.
+type ZoneObject struct {
+	ID          int           `json:"id"`
+	Name        string        `json:"name"`
+	Status      string        `json:"status"`
+	View        NamedObject   `json:"view"`
+	DefaultTTL  *int          `json:"default_ttl"`
+	SOATTL      *int          `json:"soa_ttl"`
+	SOAMName    NamedObject   `json:"soa_mname"`
+	SOARName    string        `json:"soa_rname"`
+	SOARefresh  *int          `json:"soa_refresh"`
+	SOARetry    *int          `json:"soa_retry"`
+	SOAExpire   *int          `json:"soa_expire"`
+	SOAMinimum  *int          `json:"soa_minimum"`
+	Nameservers []NamedObject `json:"nameservers"`
+}
+
+// ZoneSnapshots holds the pre-change and post-change snapshots of a zone.
+type ZoneSnapshots struct {
+	PreChange  *ZoneObject `json:"prechange"`
+	PostChange *ZoneObject `json:"postchange"`
+}
+
+// NamedObject is a related NetBox object. The data of a payload carries the
+// nested object, snapshots only carry its ID.
+type NamedObject struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// UnmarshalJSON accepts both a nested object and a plain ID.
+func (n *NamedObject) UnmarshalJSON(data []byte) error {
+	trimmed := strings.TrimSpace(string(data))
+	if trimmed == "null" {
+		*n = NamedObject{}
+		return nil
+	}
+	if !strings.HasPrefix(trimmed, "{") {
+		return json.Unmarshal(data, &n.ID)
+	}
+	type namedObject NamedObject
+	return json.Unmarshal(data, (*namedObject)(n))
+}
+
+// Zone returns the zone the event is about: the data of the payload, completed
+// from the pre-change snapshot for deleted zones.
+func (zp *ZonePayload) Zone() ZoneObject {
+	zone := zp.Data
+	if zp.Snapshots != nil && zp.Snapshots.PreChange != nil {
+		if zone.ID == 0 {
+			zone.ID = zp.Snapshots.PreChange.ID
+		}
+		if zone.Name == "" {
+			zone.Name = zp.Snapshots.PreChange.Name
+		}
+	}
+	return zone
+}
+
+// PreviousName returns the name of the zone before the change, if known.
+func (zp *ZonePayload) PreviousName() string {
+	if zp.Snapshots == nil || zp.Snapshots.PreChange == nil {
+		return ""
+	}
+	return zp.Snapshots.PreChange.Name
+}
+
+// PreviousView returns the view of the zone before the change and whether the
+// payload carries it. Snapshots only carry the ID of the view.
+func (zp *ZonePayload) PreviousView() (NamedObject, bool) {
+	if zp.Snapshots == nil || zp.Snapshots.PreChange == nil {
+		return NamedObject{}, false
+	}
+	return zp.Snapshots.PreChange.View, true
+}
+
+// normalizeIDN converts the zone names and the names of the SOA and the
+// nameservers to their ASCII form.
+func (zp *ZonePayload) normalizeIDN() error {
+	convert := func(prefix string, zone *ZoneObject) error {
+		if zone == nil {
+			return nil
+		}
+		if err := convertIDN(prefix+".name", &zone.Name, toASCIIName); err != nil {
+			return err
+		}
+		if err := convertIDN(prefix+".soa_mname.name", &zone.SOAMName.Name, toASCIIName); err != nil {
+			return err
+		}
+		if err := convertIDN(prefix+".soa_rname", &zone.SOARName, toASCIIName); err != nil {
+			return err
+		}
+		for i := range zone.Nameservers {
+			if err := convertIDN(fmt.Sprintf("%s.nameservers.%d.name", prefix, i), &zone.Nameservers[i].Name, toASCIIName); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := convert("data", &zp.Data); err != nil {
+		return err
+	}
+	if zp.Snapshots != nil {
+		if err := convert("snapshots.prechange", zp.Snapshots.PreChange); err != nil {
+			return err
+		}
+		if err := convert("snapshots.postchange", zp.Snapshots.PostChange); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate ensures that the zone payload contains the necessary data.
+func (zp *ZonePayload) Validate() error {
+	var errs ValidationErrors
+
+	switch strings.ToLower(zp.Event) {
+	case "created", "updated", "deleted":
+		zone := zp.Zone()
+		if zone.ID == 0 {
+			errs.add("data.id", "is required")
+		}
+		if zone.Name == "" {
+			errs.add("data.name", "is required")
+		} else if err := validateDomainName(zone.Name); err != nil {
+			errs.add("data.name", "%v", err)
+		}
+		if zone.DefaultTTL != nil && (*zone.DefaultTTL < 0 || *zone.DefaultTTL > maxTTL) {
+			errs.add("data.default_ttl", "must be between 0 and %d", maxTTL)
+		}
+	case "":
+		errs.add("event", "is required")
+	default:
+		errs.add("event", "unsupported event type %q", zp.Event)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
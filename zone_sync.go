@@ -0,0 +1,180 @@
+// zone_sync.go
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// soaData holds the RDATA of an SOA record.
+type soaData struct {
+	MName   string
+	RName   string
+	Serial  uint32
+	Refresh int
+	Retry   int
+	Expire  int
+	Minimum int
+}
+
+// String formats the SOA RDATA in presentation format.
+func (s soaData) String() string {
+	return fmt.Sprintf("%s %s %d %d %d %d %d", s.MName, s.RName, s.Serial, s.Refresh, s.Retry, s.Expire, s.Minimum)
+}
+
+// parseSOA parses SOA RDATA in presentation format.
+func parseSOA(value string) (soaData, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 7 {
+		return soaData{}, fmt.Errorf("invalid SOA record %q", value)
+	}
+	serial, err := strconv.ParseUint(fields[2], 10, 32)
+	if err != nil {
+		return soaData{}, fmt.Errorf("invalid SOA serial %q", fields[2])
+	}
+	var timers [4]int
+	for i := range timers {
+		if timers[i], err = strconv.Atoi(fields[3+i]); err != nil {
+			return soaData{}, fmt.Errorf("invalid SOA timer %q", fields[3+i])
+		}
+	}
+	return soaData{
+		MName:   canonicalName(fields[0]),
+		RName:   canonicalName(fields[1]),
+		Serial:  uint32(serial),
+		Refresh: timers[0],
+		Retry:   timers[1],
+		Expire:  timers[2],
+		Minimum: timers[3],
+	}, nil
+}
+
+// querySOA returns the SOA record of a zone on the DNS server and its TTL.
+func querySOA(zoneName string, config *Config) (soaData, int, error) {
+	answer, err := ExecuteDig(zoneName, "SOA", config)
+	if err != nil {
+		return soaData{}, 0, err
+	}
+	for _, rr := range answer {
+		if rr.Type == "SOA" && sameName(rr.Name, zoneName) {
+			soa, err := parseSOA(rr.Value)
+			return soa, rr.TTL, err
+		}
+	}
+	return soaData{}, 0, fmt.Errorf("no SOA record found for zone %s", zoneName)
+}
+
+// syncZoneApex pushes the SOA timers and contact and the apex NS set of a zone
+// to the DNS server in a single update. The serial is left to the server: the
+// SOA is sent with the serial the server has, which increments it itself when
+// applying the update. The SOA is read back afterwards to verify that the
+// change took effect. It returns a description of the applied changes, empty
+// if the apex was in sync.
+func syncZoneApex(zone ZoneObject, config *Config) ([]string, error) {
+	zoneName := canonicalName(zone.Name)
+
+	// Read the current apex from the server
+	current, currentSOATTL, err := querySOA(zoneName, config)
+	if err != nil {
+		return nil, err
+	}
+	nsAnswer, err := ExecuteDig(zoneName, "NS", config)
+	if err != nil {
+		return nil, err
+	}
+	var currentNS []string
+	currentNSTTL := 0
+	for _, rr := range nsAnswer {
+		if rr.Type == "NS" && sameName(rr.Name, zoneName) {
+			currentNS = append(currentNS, canonicalName(rr.Value))
+			currentNSTTL = rr.TTL
+		}
+	}
+
+	// Build the desired SOA from the zone, keeping unset fields
+	desired := current
+	if zone.SOAMName.Name != "" {
+		desired.MName = canonicalName(zone.SOAMName.Name)
+	}
+	if zone.SOARName != "" {
+		desired.RName = canonicalName(zone.SOARName)
+	}
+	desired.Refresh = intOrDefault(zone.SOARefresh, desired.Refresh)
+	desired.Retry = intOrDefault(zone.SOARetry, desired.Retry)
+	desired.Expire = intOrDefault(zone.SOAExpire, desired.Expire)
+	desired.Minimum = intOrDefault(zone.SOAMinimum, desired.Minimum)
+	soaTTL := intOrDefault(zone.SOATTL, currentSOATTL)
+
+	var changes []string
+	var soa string
+	soaChanged := desired != current || soaTTL != currentSOATTL
+	if soaChanged {
+		soa = desired.String()
+		changes = append(changes, "soa")
+	}
+
+	// Compute the apex NS changes; an empty NS set in NetBox leaves the server alone
+	var desiredNS []string
+	for _, nameserver := range zone.Nameservers {
+		if nameserver.Name != "" {
+			desiredNS = appendUnique(desiredNS, canonicalName(nameserver.Name))
+		}
+	}
+	var addNS, removeNS []string
+	if len(desiredNS) > 0 {
+		addNS = subtractTargets(desiredNS, currentNS)
+		removeNS = subtractTargets(currentNS, desiredNS)
+	}
+	if len(addNS) > 0 || len(removeNS) > 0 {
+		changes = append(changes, "ns")
+	}
+	nsTTL := currentNSTTL
+	if nsTTL <= 0 {
+		nsTTL = intOrDefault(zone.DefaultTTL, 3600)
+	}
+
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	script := ConstructZoneApexScript(
+		extractHost(config.BindServerAddress),
+		extractPort(config.BindServerAddress),
+		zoneName,
+		soa,
+		soaTTL,
+		addNS,
+		removeNS,
+		nsTTL,
+	)
+
+	logDebug("Outgoing nsupdate script for zone apex", "zone", zoneName, "script", script)
+
+	if err := ExecuteNSUpdate(script, config); err != nil {
+		return nil, err
+	}
+
+	// Verify that the server accepted the SOA instead of silently ignoring it
+	if soaChanged {
+		applied, _, err := querySOA(zoneName, config)
+		if err != nil {
+			return changes, fmt.Errorf("failed to verify SOA update: %v", err)
+		}
+		expected := desired
+		expected.Serial = applied.Serial
+		if applied != expected {
+			return changes, fmt.Errorf("SOA update did not take effect: sent %s, the server has %s", soa, applied.String())
+		}
+	}
+	return changes, nil
+}
+
+// intOrDefault returns the value of p, or fallback if p is nil or not positive.
+func intOrDefault(p *int, fallback int) int {
+	if p == nil || *p <= 0 {
+		return fallback
+	}
+	return *p
+}